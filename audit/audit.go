@@ -0,0 +1,80 @@
+package audit
+
+import (
+	"time"
+)
+
+var (
+	DefaultQueryLimit = 1000
+	MaxQueryLimit     = 10000
+)
+
+// Store saves a record of every request answered on a customer's behalf.
+// Close stops deleting expired records and releases the store's connections.
+type Store interface {
+	Put(*Record) error
+	Query(Query) ([]*Record, error)
+	Close() error
+}
+
+// Record is one request, who made it, and how it turned out.
+type Record struct {
+	Id         int64  `db:"id"`
+	CustomerId string `db:"customer_id"`
+	UserId     int32  `db:"user_id"`
+
+	// Caller is the verified identity of the client that sent the request,
+	// empty if it didn't present a certificate.
+	Caller string `db:"caller"`
+
+	// Method is the rpc the request came in on, e.g. Get or BatchGet.
+	Method string `db:"method"`
+	Region string `db:"region"`
+
+	// VpcId is the vpc the request was scoped to, or "*" if it was region
+	// wide.
+	VpcId        string    `db:"vpc_id"`
+	RequestType  string    `db:"request_type"`
+	Cached       bool      `db:"cached"`
+	AWSRequestId string    `db:"aws_request_id"`
+	Code         uint32    `db:"code"`
+	CreatedAt    time.Time `db:"created_at"`
+}
+
+// Query selects a customer's records created in [Since, Until), newest
+// first. A zero Until means now, and Limit defaults to DefaultQueryLimit.
+type Query struct {
+	CustomerId string
+	Since      time.Time
+	Until      time.Time
+	Limit      int
+}
+
+// withDefaults fills in Until and Limit, and caps Limit at MaxQueryLimit.
+func (q Query) withDefaults() Query {
+	if q.Until.IsZero() {
+		q.Until = time.Now().UTC()
+	}
+
+	if q.Limit <= 0 {
+		q.Limit = DefaultQueryLimit
+	}
+
+	if q.Limit > MaxQueryLimit {
+		q.Limit = MaxQueryLimit
+	}
+
+	return q
+}
+
+func (q Query) validate() error {
+	if q.CustomerId == "" {
+		return errMissingCustomerId
+	}
+
+	if !q.Until.IsZero() && q.Until.Before(q.Since) {
+		return errInvalidTimeRange
+	}
+
+	return nil
+}
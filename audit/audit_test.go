@@ -0,0 +1,52 @@
+package audit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQueryValidate(t *testing.T) {
+	now := time.Now()
+
+	for _, test := range []struct {
+		name  string
+		query Query
+		err   error
+	}{
+		{"no customer", Query{Since: now}, errMissingCustomerId},
+		{"ends before it starts", Query{CustomerId: "customer", Since: now, Until: now.Add(-time.Second)}, errInvalidTimeRange},
+		{"open ended", Query{CustomerId: "customer", Since: now}, nil},
+		{"bounded", Query{CustomerId: "customer", Since: now, Until: now.Add(time.Second)}, nil},
+	} {
+		if err := test.query.validate(); err != test.err {
+			t.Errorf("%s: expected %v, got %v", test.name, test.err, err)
+		}
+	}
+}
+
+func TestQueryWithDefaults(t *testing.T) {
+	until := time.Now().Add(-time.Hour)
+
+	for _, test := range []struct {
+		name  string
+		query Query
+		limit int
+	}{
+		{"no limit", Query{}, DefaultQueryLimit},
+		{"limit", Query{Limit: 10}, 10},
+		{"limit over the max", Query{Limit: MaxQueryLimit + 1}, MaxQueryLimit},
+	} {
+		q := test.query.withDefaults()
+		if q.Limit != test.limit {
+			t.Errorf("%s: expected limit %d, got %d", test.name, test.limit, q.Limit)
+		}
+
+		if q.Until.IsZero() {
+			t.Errorf("%s: expected until to default to now", test.name)
+		}
+	}
+
+	if q := (Query{Until: until}).withDefaults(); !q.Until.Equal(until) {
+		t.Errorf("expected until to be kept, got %v", q.Until)
+	}
+}
@@ -0,0 +1,10 @@
+package audit
+
+import (
+	"errors"
+)
+
+var (
+	errMissingCustomerId = errors.New("missing customer id")
+	errInvalidTimeRange  = errors.New("query ends before it starts")
+)
@@ -0,0 +1,45 @@
+package audit
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/opsee/logrus"
+)
+
+// expirer deletes expired records every interval until it's stopped.
+type expirer struct {
+	done chan struct{}
+	once sync.Once
+}
+
+func newExpirer() *expirer {
+	return &expirer{
+		done: make(chan struct{}),
+	}
+}
+
+func (e *expirer) start(interval time.Duration, expire func() error) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-e.done:
+				return
+
+			case <-ticker.C:
+				if err := expire(); err != nil {
+					log.WithError(err).Error("error deleting expired audit records")
+				}
+			}
+		}
+	}()
+}
+
+func (e *expirer) stop() {
+	e.once.Do(func() {
+		close(e.done)
+	})
+}
@@ -0,0 +1,101 @@
+package audit
+
+import (
+	"sync"
+	"time"
+)
+
+type memory struct {
+	sync.Mutex
+	retention time.Duration
+	records   []*Record
+	lastId    int64
+	expirer   *expirer
+}
+
+// NewMemory returns a Store that keeps records in process, deleting those
+// older than retention every interval. Zero values are replaced with
+// DefaultRetention and DefaultRetentionInterval.
+func NewMemory(retention, interval time.Duration) Store {
+	return newMemory(retention, interval)
+}
+
+func newMemory(retention, interval time.Duration) *memory {
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+
+	if interval <= 0 {
+		interval = DefaultRetentionInterval
+	}
+
+	s := &memory{
+		retention: retention,
+		expirer:   newExpirer(),
+	}
+
+	s.expirer.start(interval, s.expire)
+
+	return s
+}
+
+func (s *memory) Put(record *Record) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.lastId++
+
+	saved := *record
+	saved.Id = s.lastId
+	saved.CreatedAt = time.Now().UTC()
+
+	s.records = append(s.records, &saved)
+	return nil
+}
+
+func (s *memory) Query(q Query) ([]*Record, error) {
+	if err := q.validate(); err != nil {
+		return nil, err
+	}
+
+	q = q.withDefaults()
+
+	s.Lock()
+	defer s.Unlock()
+
+	var records []*Record
+
+	// records are kept oldest first
+	for i := len(s.records) - 1; i >= 0 && len(records) < q.Limit; i-- {
+		r := s.records[i]
+		if r.CustomerId != q.CustomerId || r.CreatedAt.Before(q.Since) || !r.CreatedAt.Before(q.Until) {
+			continue
+		}
+
+		copied := *r
+		records = append(records, &copied)
+	}
+
+	return records, nil
+}
+
+func (s *memory) Close() error {
+	s.expirer.stop()
+	return nil
+}
+
+// expire deletes records older than the retention period.
+func (s *memory) expire() error {
+	cutoff := time.Now().UTC().Add(-1 * s.retention)
+
+	s.Lock()
+	defer s.Unlock()
+
+	var kept int
+	for kept < len(s.records) && s.records[kept].CreatedAt.Before(cutoff) {
+		kept++
+	}
+
+	s.records = s.records[kept:]
+	return nil
+}
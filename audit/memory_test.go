@@ -0,0 +1,142 @@
+package audit
+
+import (
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMemoryQuery(t *testing.T) {
+	s := newMemory(time.Hour, time.Hour)
+	defer s.Close()
+
+	for _, record := range []*Record{
+		{CustomerId: "customer", RequestType: "first"},
+		{CustomerId: "other", RequestType: "other"},
+		{CustomerId: "customer", RequestType: "second"},
+		{CustomerId: "customer", RequestType: "third"},
+	} {
+		if err := s.Put(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	all, err := s.Query(Query{CustomerId: "customer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if types := requestTypes(all); !reflect.DeepEqual(types, []string{"third", "second", "first"}) {
+		t.Fatalf("expected the customer's records newest first, got %v", types)
+	}
+
+	for i, record := range all {
+		if record.Id == 0 || record.CreatedAt.IsZero() {
+			t.Errorf("record %d: expected an id and creation time, got %d and %v", i, record.Id, record.CreatedAt)
+		}
+	}
+
+	for _, test := range []struct {
+		name  string
+		query Query
+		types []string
+		err   error
+	}{
+		{
+			name:  "limit",
+			query: Query{CustomerId: "customer", Limit: 2},
+			types: []string{"third", "second"},
+		},
+		{
+			name:  "since is inclusive",
+			query: Query{CustomerId: "customer", Since: all[1].CreatedAt},
+			types: []string{"third", "second"},
+		},
+		{
+			name:  "until is exclusive",
+			query: Query{CustomerId: "customer", Since: all[2].CreatedAt, Until: all[1].CreatedAt},
+			types: []string{"first"},
+		},
+		{
+			name:  "no such customer",
+			query: Query{CustomerId: "nobody"},
+		},
+		{
+			name:  "no customer",
+			query: Query{},
+			err:   errMissingCustomerId,
+		},
+		{
+			name:  "ends before it starts",
+			query: Query{CustomerId: "customer", Since: all[0].CreatedAt, Until: all[2].CreatedAt},
+			err:   errInvalidTimeRange,
+		},
+	} {
+		records, err := s.Query(test.query)
+		if err != test.err {
+			t.Errorf("%s: expected %v, got %v", test.name, test.err, err)
+			continue
+		}
+
+		if types := requestTypes(records); !reflect.DeepEqual(types, test.types) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.types, types)
+		}
+	}
+}
+
+func TestMemoryExpire(t *testing.T) {
+	s := newMemory(20*time.Millisecond, time.Hour)
+	defer s.Close()
+
+	s.Put(&Record{CustomerId: "customer", RequestType: "old"})
+	time.Sleep(30 * time.Millisecond)
+	s.Put(&Record{CustomerId: "customer", RequestType: "new"})
+
+	if err := s.expire(); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := s.Query(Query{CustomerId: "customer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if types := requestTypes(records); !reflect.DeepEqual(types, []string{"new"}) {
+		t.Errorf("expected only records within the retention period, got %v", types)
+	}
+}
+
+func TestExpirerStop(t *testing.T) {
+	var runs int64
+
+	e := newExpirer()
+	e.start(time.Millisecond, func() error {
+		atomic.AddInt64(&runs, 1)
+		return nil
+	})
+
+	time.Sleep(20 * time.Millisecond)
+	e.stop()
+	e.stop()
+
+	// let a tick that was already underway finish
+	time.Sleep(5 * time.Millisecond)
+	stopped := atomic.LoadInt64(&runs)
+	if stopped == 0 {
+		t.Fatal("expected records to be expired every interval")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if n := atomic.LoadInt64(&runs); n != stopped {
+		t.Errorf("expected no runs after stopping, got %d more", n-stopped)
+	}
+}
+
+func requestTypes(records []*Record) []string {
+	var types []string
+	for _, record := range records {
+		types = append(types, record.RequestType)
+	}
+	return types
+}
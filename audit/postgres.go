@@ -0,0 +1,137 @@
+package audit
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	"github.com/opsee/bezosphere/store"
+	log "github.com/opsee/logrus"
+)
+
+var (
+	DefaultRetention          = 90 * 24 * time.Hour
+	DefaultRetentionInterval  = time.Hour
+	DefaultRetentionBatchSize = 10000
+)
+
+// retentionLock is the advisory lock key held by the replica deleting
+// expired records.
+const retentionLock = "bezosphere/audit-retention"
+
+// PostgresConfig configures a postgres backed audit store. Zero values are
+// replaced with the defaults above.
+type PostgresConfig struct {
+	Connection string
+
+	// Retention is how long records are kept. Expired records are deleted
+	// every RetentionInterval, by one replica at a time.
+	Retention         time.Duration
+	RetentionInterval time.Duration
+}
+
+type postgres struct {
+	db      *sqlx.DB
+	config  PostgresConfig
+	expirer *expirer
+}
+
+// NewPostgres returns a Store backed by the audit_records table, and starts
+// deleting records older than the retention period.
+func NewPostgres(config PostgresConfig) (Store, error) {
+	if config.Retention <= 0 {
+		config.Retention = DefaultRetention
+	}
+
+	if config.RetentionInterval <= 0 {
+		config.RetentionInterval = DefaultRetentionInterval
+	}
+
+	db, err := sqlx.Open("postgres", config.Connection)
+	if err != nil {
+		return nil, err
+	}
+
+	db.SetMaxOpenConns(4)
+	db.SetMaxIdleConns(4)
+
+	s := &postgres{
+		db:      db,
+		config:  config,
+		expirer: newExpirer(),
+	}
+
+	s.expirer.start(config.RetentionInterval, s.expire)
+
+	return s, nil
+}
+
+func (s *postgres) Put(record *Record) error {
+	_, err := sqlx.NamedExec(
+		s.db,
+		`insert into audit_records (customer_id, user_id, caller, method, region, vpc_id, request_type, cached, aws_request_id, code)
+		 values (:customer_id, :user_id, :caller, :method, :region, :vpc_id, :request_type, :cached, :aws_request_id, :code)`,
+		record,
+	)
+
+	return err
+}
+
+func (s *postgres) Query(q Query) ([]*Record, error) {
+	if err := q.validate(); err != nil {
+		return nil, err
+	}
+
+	q = q.withDefaults()
+
+	var records []*Record
+	err := s.db.Select(
+		&records,
+		`select * from audit_records where customer_id = $1 and created_at >= $2 and created_at < $3
+		 order by created_at desc limit $4`,
+		q.CustomerId,
+		q.Since,
+		q.Until,
+		q.Limit,
+	)
+
+	return records, err
+}
+
+func (s *postgres) Close() error {
+	s.expirer.stop()
+	return s.db.Close()
+}
+
+// expire deletes records older than the retention period in batches, unless
+// another replica is already doing it.
+func (s *postgres) expire() error {
+	_, err := store.TryLocked(s.db, retentionLock, func() error {
+		cutoff := time.Now().UTC().Add(-1 * s.config.Retention)
+
+		_, total, err := store.DeleteInBatches(DefaultRetentionBatchSize, func() (int64, int64, error) {
+			res, err := s.db.Exec(
+				`delete from audit_records where id in (
+				   select id from audit_records where created_at < $1 limit $2
+				 )`,
+				cutoff,
+				DefaultRetentionBatchSize,
+			)
+			if err != nil {
+				return 0, 0, err
+			}
+
+			// every row found is deleted, there's no race to lose
+			deleted, err := res.RowsAffected()
+			return deleted, deleted, err
+		})
+		if err != nil {
+			return err
+		}
+
+		log.WithField("rows_deleted", total).Info("deleted expired audit records")
+		return nil
+	})
+
+	return err
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"reflect"
+
+	opsee "github.com/opsee/basic/service"
+	"github.com/opsee/bezosphere/store"
+	log "github.com/opsee/logrus"
+	"github.com/spf13/viper"
+)
+
+// bezosphere-backfill rewrites cached resources in BEZOSPHERE_ENCODING, which
+// defaults to protobuf. It's safe to run alongside bezosphere, and to run
+// again if it's interrupted.
+func main() {
+	viper.SetEnvPrefix("bezosphere")
+	viper.AutomaticEnv()
+	viper.SetDefault("encoding", store.EncodingProto)
+
+	masterKeys, err := store.ParseMasterKeys(viper.GetString("master_keys"))
+	if err != nil {
+		log.Fatal("failed to parse master keys: ", err)
+	}
+
+	db, err := store.NewPostgres(store.PostgresConfig{
+		Connection: viper.GetString("postgres_conn"),
+		MasterKeys: masterKeys,
+	})
+	if err != nil {
+		log.Fatal("failed to initialize postgres: ", err)
+	}
+
+	n, err := store.Reencode(db, viper.GetString("encoding"), outputs(), viper.GetInt("backfill_batch_size"))
+	if err != nil {
+		log.WithField("rewritten", n).Fatal("failed to re-encode resources: ", err)
+	}
+
+	log.WithField("rewritten", n).Info("done re-encoding resources")
+}
+
+// outputs returns an empty value of every output a BezosResponse can hold,
+// which are all the outputs bezosphere caches.
+func outputs() []interface{} {
+	_, _, _, oneofs := (*opsee.BezosResponse)(nil).XXX_OneofFuncs()
+
+	outputs := make([]interface{}, 0, len(oneofs))
+	for _, oneof := range oneofs {
+		// each oneof wrapper has a single field holding the output
+		field := reflect.TypeOf(oneof).Elem().Field(0)
+		outputs = append(outputs, reflect.New(field.Type.Elem()).Interface())
+	}
+
+	return outputs
+}
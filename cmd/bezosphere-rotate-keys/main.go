@@ -0,0 +1,56 @@
+package main
+
+import (
+	"github.com/opsee/bezosphere/store"
+	log "github.com/opsee/logrus"
+	"github.com/spf13/viper"
+)
+
+// bezosphere-rotate-keys rewraps every customer's data keys with the first of
+// BEZOSPHERE_MASTER_KEYS, gives every customer a new data key unless
+// BEZOSPHERE_ROTATE_DATA_KEYS is false, then re-encrypts their resources with
+// it. Replicas pick up new data keys within store.DefaultKeyCacheTTL, so run it
+// again after that to catch anything they wrote with the old ones. It's safe to
+// run alongside bezosphere.
+func main() {
+	viper.SetEnvPrefix("bezosphere")
+	viper.AutomaticEnv()
+	viper.SetDefault("rotate_data_keys", true)
+
+	masterKeys, err := store.ParseMasterKeys(viper.GetString("master_keys"))
+	if err != nil {
+		log.Fatal("failed to parse master keys: ", err)
+	}
+
+	if len(masterKeys) == 0 {
+		log.Fatal("no master keys given")
+	}
+
+	db, err := store.NewPostgres(store.PostgresConfig{
+		Connection: viper.GetString("postgres_conn"),
+		MasterKeys: masterKeys,
+	})
+	if err != nil {
+		log.Fatal("failed to initialize postgres: ", err)
+	}
+
+	n, err := store.RewrapKeys(db)
+	if err != nil {
+		log.WithField("rewrapped", n).Fatal("failed to rewrap data keys: ", err)
+	}
+	log.WithField("rewrapped", n).Info("rewrapped data keys")
+
+	if viper.GetBool("rotate_data_keys") {
+		n, err = store.RotateKeys(db)
+		if err != nil {
+			log.WithField("rotated", n).Fatal("failed to rotate data keys: ", err)
+		}
+		log.WithField("rotated", n).Info("rotated data keys")
+	}
+
+	n, err = store.Reencrypt(db, viper.GetInt("backfill_batch_size"))
+	if err != nil {
+		log.WithField("rewritten", n).Fatal("failed to re-encrypt resources: ", err)
+	}
+	log.WithField("rewritten", n).Info("done re-encrypting resources")
+}
@@ -1,6 +1,11 @@
 package main
 
 import (
+	"reflect"
+	"strings"
+
+	opsee "github.com/opsee/basic/service"
+	"github.com/opsee/bezosphere/audit"
 	"github.com/opsee/bezosphere/service"
 	"github.com/opsee/bezosphere/store"
 	log "github.com/opsee/logrus"
@@ -11,26 +16,138 @@ func main() {
 	viper.SetEnvPrefix("bezosphere")
 	viper.AutomaticEnv()
 
-	db, err := store.NewPostgres(
-		viper.GetString("postgres_conn"),
-	)
+	policy, err := store.ParsePolicy(viper.GetString("ttl_policy"), inputs())
+	if err != nil {
+		log.Fatal("failed to parse ttl policy: ", err)
+	}
+
+	negativeTTLs, err := service.ParseNegativeTTLs(viper.GetString("negative_ttls"))
+	if err != nil {
+		log.Fatal("failed to parse negative ttls: ", err)
+	}
+
+	masterKeys, err := store.ParseMasterKeys(viper.GetString("master_keys"))
+	if err != nil {
+		log.Fatal("failed to parse master keys: ", err)
+	}
+
+	postgresConfig := store.PostgresConfig{
+		Connection: viper.GetString("postgres_conn"),
+		Policy:     policy,
+		Encoding:   viper.GetString("encoding"),
+		MasterKeys: masterKeys,
+		LockConns:  viper.GetInt("lock_conns"),
+	}
+
+	var db store.Store
+
+	switch viper.GetString("store") {
+	case "memory":
+		db = store.NewMemory(int64(viper.GetInt("memory_max_bytes")), policy)
+
+	case "layered":
+		db, err = store.NewLayered(postgresConfig, int64(viper.GetInt("memory_max_bytes")))
+
+		if err != nil {
+			log.Fatal("failed to initialize layered store: ", err)
+		}
+
+	case "", "postgres":
+		db, err = store.NewPostgres(postgresConfig)
+
+		if err != nil {
+			log.Fatal("failed to initialize postgres: ", err)
+		}
+
+	default:
+		log.Fatal("unknown store: ", viper.GetString("store"))
+	}
+
+	janitor, err := store.NewJanitor(db, store.JanitorConfig{
+		Retention: viper.GetDuration("retention"),
+		Interval:  viper.GetDuration("janitor_interval"),
+		BatchSize: viper.GetInt("janitor_batch_size"),
+	})
 
 	if err != nil {
-		log.Fatal("failed to initialize postgres: ", err)
+		log.WithError(err).Warn("not running the janitor")
+	} else {
+		janitor.Start()
+	}
+
+	var auditStore audit.Store
+
+	if viper.GetBool("audit") {
+		auditStore, err = audit.NewPostgres(audit.PostgresConfig{
+			Connection:        viper.GetString("postgres_conn"),
+			Retention:         viper.GetDuration("audit_retention"),
+			RetentionInterval: viper.GetDuration("audit_retention_interval"),
+		})
+
+		if err != nil {
+			log.Fatal("failed to initialize audit log: ", err)
+		}
 	}
 
 	server, err := service.New(service.Config{
-		SpanxAddress: viper.GetString("spanx_address"),
-		Db:           db,
+		SpanxAddress:           viper.GetString("spanx_address"),
+		Db:                     db,
+		Janitor:                janitor,
+		BatchConcurrency:       viper.GetInt("batch_concurrency"),
+		MaxBatchSize:           viper.GetInt("max_batch_size"),
+		WatchInterval:          viper.GetDuration("watch_interval"),
+		MaxPages:               viper.GetInt("max_pages"),
+		MaxPageItems:           viper.GetInt("max_page_items"),
+		ReplicaLocking:         viper.GetBool("replica_locking"),
+		StaleWhileRevalidate:   viper.GetDuration("stale_while_revalidate"),
+		StaleIfError:           viper.GetDuration("stale_if_error"),
+		MaxRequestTimeout:      viper.GetDuration("max_request_timeout"),
+		CredentialExpiryWindow: viper.GetDuration("credential_expiry_window"),
+		SessionIdleTimeout:     viper.GetDuration("session_idle_timeout"),
+		ClientCAFile:           viper.GetString("client_ca"),
+		AllowedClients:         strings.Split(viper.GetString("allowed_clients"), ","),
+		Audit:                  auditStore,
+		RateLimit:              viper.GetFloat64("rate_limit"),
+		RateBurst:              viper.GetInt("rate_burst"),
+		BreakerThreshold:       viper.GetInt("breaker_threshold"),
+		BreakerCooldown:        viper.GetDuration("breaker_cooldown"),
+		NegativeTTLs:           negativeTTLs,
+		TTLPolicy:              policy,
 	})
 
 	if err != nil {
 		log.Fatal("failed to create new service: ", err)
 	}
 
+	if debugAddress := viper.GetString("debug_address"); debugAddress != "" {
+		go func() {
+			log.Fatal(server.StartDebug(debugAddress))
+		}()
+	}
+
+	if metricsAddress := viper.GetString("metrics_address"); metricsAddress != "" {
+		go func() {
+			log.Fatal(server.StartMetrics(metricsAddress))
+		}()
+	}
+
 	log.Fatal(server.Start(
 		viper.GetString("address"),
 		viper.GetString("cert"),
 		viper.GetString("cert_key"),
 	))
 }
+
+// inputs returns an empty value of every input a BezosRequest can hold.
+func inputs() []interface{} {
+	_, _, _, oneofs := (*opsee.BezosRequest)(nil).XXX_OneofFuncs()
+
+	inputs := make([]interface{}, 0, len(oneofs))
+	for _, oneof := range oneofs {
+		// each oneof wrapper has a single field holding the input
+		field := reflect.TypeOf(oneof).Elem().Field(0)
+		inputs = append(inputs, reflect.New(field.Type.Elem()).Interface())
+	}
+
+	return inputs
+}
@@ -0,0 +1,321 @@
+// Package metrics keeps counters and histograms in process, and serves them
+// in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are histogram bucket upper bounds in seconds, suitable for
+// timing network calls.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+// Registry holds every metric to be exposed, in the order they're written.
+type Registry struct {
+	sync.Mutex
+	metrics []metric
+	names   map[string]bool
+}
+
+type metric interface {
+	name() string
+	write(w io.Writer)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		names: make(map[string]bool),
+	}
+}
+
+func (r *Registry) register(m metric) {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.names[m.name()] {
+		panic("metrics: " + m.name() + " is already registered")
+	}
+
+	r.names[m.name()] = true
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteTo writes every metric in the text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.Unlock()
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	for _, m := range metrics {
+		m.write(cw)
+	}
+
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+
+	return cw.n, cw.w.(*bufio.Writer).Flush()
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.WriteTo(w)
+}
+
+// Counter is a family of counters, one for each combination of label values.
+type Counter struct {
+	family
+	values map[string]float64
+}
+
+// NewCounter registers a counter with the given label names.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{
+		family: family{metricName: name, help: help, typ: "counter", labels: labels},
+		values: make(map[string]float64),
+	}
+
+	r.register(c)
+	return c
+}
+
+// Inc adds one to the counter with the given label values.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v, which must not be negative, to the counter with the given label
+// values.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	key := c.key(labelValues)
+
+	c.Lock()
+	c.values[key] += v
+	c.Unlock()
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.header(w)
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.metricName, key, formatFloat(c.values[key]))
+	}
+}
+
+// Histogram is a family of histograms, one for each combination of label
+// values.
+type Histogram struct {
+	family
+	buckets []float64
+	values  map[string]*histogramValue
+}
+
+type histogramValue struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram registers a histogram with the given bucket upper bounds, in
+// increasing order, and label names.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		family:  family{metricName: name, help: help, typ: "histogram", labels: labels},
+		buckets: buckets,
+		values:  make(map[string]*histogramValue),
+	}
+
+	r.register(h)
+	return h
+}
+
+// Observe adds v to the histogram with the given label values.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	key := h.key(labelValues)
+
+	h.Lock()
+	defer h.Unlock()
+
+	hv, ok := h.values[key]
+	if !ok {
+		hv = &histogramValue{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hv
+	}
+
+	for i, upper := range h.buckets {
+		if v <= upper {
+			hv.counts[i]++
+		}
+	}
+
+	hv.count++
+	hv.sum += v
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.header(w)
+
+	keys := make([]string, 0, len(h.values))
+	for key := range h.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		hv := h.values[key]
+
+		for i, upper := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, withLabel(key, "le", formatFloat(upper)), hv.counts[i])
+		}
+
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, withLabel(key, "le", "+Inf"), hv.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metricName, key, formatFloat(hv.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metricName, key, hv.count)
+	}
+}
+
+// Func is a metric whose value is read when it's written, for numbers that
+// are kept somewhere else.
+type Func struct {
+	family
+	fn func() float64
+}
+
+// NewGaugeFunc registers a gauge that reads its value from fn.
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) *Func {
+	f := &Func{
+		family: family{metricName: name, help: help, typ: "gauge"},
+		fn:     fn,
+	}
+
+	r.register(f)
+	return f
+}
+
+// NewCounterFunc registers a counter that reads its value from fn.
+func (r *Registry) NewCounterFunc(name, help string, fn func() float64) *Func {
+	f := &Func{
+		family: family{metricName: name, help: help, typ: "counter"},
+		fn:     fn,
+	}
+
+	r.register(f)
+	return f
+}
+
+func (f *Func) write(w io.Writer) {
+	f.header(w)
+	fmt.Fprintf(w, "%s %s\n", f.metricName, formatFloat(f.fn()))
+}
+
+// family is what every kind of metric has in common.
+type family struct {
+	sync.Mutex
+	metricName string
+	help       string
+	typ        string
+	labels     []string
+}
+
+func (f *family) name() string {
+	return f.metricName
+}
+
+func (f *family) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.metricName, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.metricName, f.typ)
+}
+
+// key formats label values as they're written, e.g. {code="OK"}. Missing
+// values are empty, extra ones are dropped.
+func (f *family) key(values []string) string {
+	if len(f.labels) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(f.labels))
+	for i, label := range f.labels {
+		var value string
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs[i] = label + `="` + escapeLabel(value) + `"`
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func withLabel(key, label, value string) string {
+	pair := label + `="` + value + `"`
+	if key == "" {
+		return "{" + pair + "}"
+	}
+
+	return key[:len(key)-1] + "," + pair + "}"
+}
+
+func sortedKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
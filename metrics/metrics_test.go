@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestExposition(t *testing.T) {
+	r := NewRegistry()
+
+	requests := r.NewCounter("requests_total", "Requests, by code.", "method", "code")
+	requests.Inc("Get", "OK")
+	requests.Inc("Get", "OK")
+	requests.Inc("Watch", `Not"Found`)
+
+	duration := r.NewHistogram("duration_seconds", "Time spent.", []float64{.1, 1}, "operation")
+	duration.Observe(.05, "ec2.DescribeInstances")
+	duration.Observe(.5, "ec2.DescribeInstances")
+	duration.Observe(5, "ec2.DescribeInstances")
+
+	r.NewGaugeFunc("open_connections", "Open\nconnections.", func() float64 { return 3 })
+
+	var buf bytes.Buffer
+	if _, err := r.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# HELP requests_total Requests, by code.
+# TYPE requests_total counter
+requests_total{method="Get",code="OK"} 2
+requests_total{method="Watch",code="Not\"Found"} 1
+# HELP duration_seconds Time spent.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{operation="ec2.DescribeInstances",le="0.1"} 1
+duration_seconds_bucket{operation="ec2.DescribeInstances",le="1"} 2
+duration_seconds_bucket{operation="ec2.DescribeInstances",le="+Inf"} 3
+duration_seconds_sum{operation="ec2.DescribeInstances"} 5.55
+duration_seconds_count{operation="ec2.DescribeInstances"} 3
+# HELP open_connections Open\nconnections.
+# TYPE open_connections gauge
+open_connections 3
+`
+
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+func TestDuplicateName(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("requests_total", "Requests.")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected registering a name twice to panic")
+		}
+	}()
+
+	r.NewCounter("requests_total", "Requests.")
+}
@@ -0,0 +1,7 @@
+truncate resources;
+
+alter table resources drop constraint resources_pkey;
+alter table resources add primary key (customer_id, id);
+
+alter table resources drop column vpc_id;
+alter table resources drop column region;
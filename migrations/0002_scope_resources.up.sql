@@ -0,0 +1,9 @@
+-- rows cached before this migration were keyed without region or vpc, so any
+-- of them could belong to the wrong scope. it's a cache, throw them away.
+truncate resources;
+
+alter table resources add column region character varying(32) not null;
+alter table resources add column vpc_id character varying(32) not null;
+
+alter table resources drop constraint resources_pkey;
+alter table resources add primary key (customer_id, region, vpc_id, id);
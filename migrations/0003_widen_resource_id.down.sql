@@ -0,0 +1,3 @@
+truncate resources;
+
+alter table resources alter column id type character varying(21);
@@ -0,0 +1,5 @@
+-- resource ids are now hex sha256 digests of the canonical request, none of
+-- the old crc64 ids will ever be looked up again.
+truncate resources;
+
+alter table resources alter column id type character varying(64);
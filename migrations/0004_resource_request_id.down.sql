@@ -0,0 +1 @@
+alter table resources drop column aws_request_id;
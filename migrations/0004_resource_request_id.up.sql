@@ -0,0 +1 @@
+alter table resources add column aws_request_id character varying(64) not null default '';
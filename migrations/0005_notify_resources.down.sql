@@ -0,0 +1,2 @@
+drop trigger notify_resources on resources;
+drop function notify_resources();
@@ -0,0 +1,19 @@
+-- tell bezosphere replicas when a resource changes, so they can drop their
+-- in-memory copy. the payload is the resource's store key.
+create function notify_resources() returns trigger
+    language plpgsql
+    as $$
+      declare
+        r record;
+      begin
+      if tg_op = 'DELETE' then
+        r := old;
+      else
+        r := new;
+      end if;
+      perform pg_notify('resources', r.customer_id || '/' || r.region || '/' || r.vpc_id || '/' || r.id);
+      return null;
+      end;
+      $$;
+
+create trigger notify_resources after insert or update or delete on resources for each row execute procedure notify_resources();
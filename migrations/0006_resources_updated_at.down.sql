@@ -0,0 +1 @@
+drop index resources_updated_at;
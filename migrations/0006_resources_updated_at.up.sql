@@ -0,0 +1,2 @@
+-- lets the janitor find expired resources without scanning the table
+create index resources_updated_at on resources (updated_at);
@@ -0,0 +1,8 @@
+drop trigger update_resources on resources;
+create trigger update_resources before update on resources for each row execute procedure update_time();
+
+-- there's nowhere to put protobuf responses anymore
+delete from resources where encoding <> 'json';
+alter table resources alter column response_data set not null;
+alter table resources drop column response_blob;
+alter table resources drop column encoding;
@@ -0,0 +1,10 @@
+-- responses can be saved as gzipped protobuf in response_blob instead of json
+-- in response_data, encoding says which
+alter table resources add column encoding character varying(16) not null default 'json';
+alter table resources add column response_blob bytea;
+alter table resources alter column response_data drop not null;
+
+-- re-encoding a resource doesn't make it any newer. puts set updated_at
+-- themselves, so they're still covered when they change the encoding.
+drop trigger update_resources on resources;
+create trigger update_resources before update on resources for each row when (new.encoding = old.encoding) execute procedure update_time();
@@ -0,0 +1,10 @@
+drop trigger update_resources on resources;
+create trigger update_resources before update on resources for each row when (new.encoding = old.encoding) execute procedure update_time();
+
+-- there's no way to read encrypted resources anymore
+delete from resources where key_version is not null;
+alter table resources alter column request_data set not null;
+alter table resources drop column request_blob;
+alter table resources drop column key_version;
+
+drop table customer_keys;
@@ -0,0 +1,20 @@
+-- per-customer data keys, wrapped by a master key that never touches the db.
+-- resources are encrypted with their customer's latest version.
+create table customer_keys (
+  customer_id UUID not null,
+  version integer not null,
+  wrapped_key bytea not null,
+  master_key_id character varying(64) not null,
+  created_at timestamp with time zone DEFAULT now() NOT NULL,
+  primary key (customer_id, version)
+);
+
+-- encrypted resources keep their request and response in the blob columns,
+-- key_version is null for plaintext ones
+alter table resources add column key_version integer;
+alter table resources add column request_blob bytea;
+alter table resources alter column request_data drop not null;
+
+-- re-encrypting a resource doesn't make it any newer either
+drop trigger update_resources on resources;
+create trigger update_resources before update on resources for each row when (new.encoding = old.encoding and new.key_version is not distinct from old.key_version) execute procedure update_time();
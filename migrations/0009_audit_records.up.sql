@@ -0,0 +1,21 @@
+-- one row for every request bezosphere answers on a customer's behalf
+create table audit_records (
+  id bigserial primary key,
+  customer_id UUID not null,
+  user_id integer not null,
+  caller character varying(255) not null,
+  method character varying(32) not null,
+  region character varying(32) not null,
+  vpc_id character varying(32) not null,
+  request_type character varying(64) not null,
+  cached boolean not null,
+  aws_request_id character varying(64) not null,
+  code integer not null,
+  created_at timestamp with time zone DEFAULT now() NOT NULL
+);
+
+-- queries are always for a customer over a time range
+create index audit_records_customer_created_at on audit_records (customer_id, created_at);
+
+-- lets retention find expired records without scanning the table
+create index audit_records_created_at on audit_records (created_at);
@@ -0,0 +1,190 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	opsee "github.com/opsee/basic/service"
+	"github.com/opsee/bezosphere/audit"
+	log "github.com/opsee/logrus"
+	opsee_types "github.com/opsee/protobuf/opseeproto/types"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	DefaultAuditBufferSize = 1000
+)
+
+var (
+	ErrAuditDisabled    = errors.New("audit logging is not enabled.")
+	ErrInvalidTimeRange = errors.New("time range ends before it starts.")
+)
+
+// auditor saves audit records in the background, so requests don't wait on
+// the audit store. If it falls more than a buffer behind, records are dropped
+// and logged instead.
+type auditor struct {
+	store   audit.Store
+	records chan *audit.Record
+}
+
+func newAuditor(store audit.Store, bufferSize int) *auditor {
+	a := &auditor{
+		store:   store,
+		records: make(chan *audit.Record, bufferSize),
+	}
+
+	go a.run()
+
+	return a
+}
+
+func (a *auditor) run() {
+	for record := range a.records {
+		if err := a.store.Put(record); err != nil {
+			logAuditRecord(record).WithError(err).Error("error saving audit record")
+		}
+	}
+}
+
+func (a *auditor) record(record *audit.Record) {
+	select {
+	case a.records <- record:
+	default:
+		logAuditRecord(record).Error("audit log is behind, dropping record")
+	}
+}
+
+func logAuditRecord(record *audit.Record) *log.Entry {
+	return log.WithFields(log.Fields{
+		"customer_id":    record.CustomerId,
+		"user_id":        record.UserId,
+		"caller":         record.Caller,
+		"method":         record.Method,
+		"request_type":   record.RequestType,
+		"aws_request_id": record.AWSRequestId,
+		"code":           record.Code,
+	})
+}
+
+// audit records a validated request and how it was answered, if audit
+// logging is on. Region wide requests are recorded under regionWideVpcId
+// rather than the vpc they were sent with, since they weren't scoped to it.
+func (s *service) audit(ctx context.Context, method string, req *opsee.BezosRequest, opts options, res *resolution, err error) {
+	if s.auditor == nil {
+		return
+	}
+
+	record := &audit.Record{
+		CustomerId:  req.User.CustomerId,
+		UserId:      req.User.Id,
+		Caller:      callerFromContext(ctx),
+		Method:      method,
+		Region:      req.Region,
+		VpcId:       auditVpcId(req, opts),
+		RequestType: requestType(req),
+		Code:        uint32(codes.OK),
+	}
+
+	if err != nil {
+		status := statusOf(err)
+		record.Code = uint32(status.code)
+		record.AWSRequestId = status.requestId
+	} else {
+		record.Cached = res.cached
+		record.AWSRequestId = res.meta.AWSRequestId
+	}
+
+	s.auditor.record(record)
+}
+
+// auditVpcId is the scope a request was answered in.
+func auditVpcId(req *opsee.BezosRequest, opts options) string {
+	if opts.regionWide {
+		return regionWideVpcId
+	}
+
+	return req.VpcId
+}
+
+// QueryAudit returns a customer's audit records. Users need permAuditRead,
+// and only Opsee admins can see other customers' records.
+func (s *service) QueryAudit(ctx context.Context, req *QueryAuditRequest) (*QueryAuditResponse, error) {
+	logger := log.WithField("caller", callerFromContext(ctx))
+
+	if s.auditor == nil {
+		return nil, grpcError(ctx, ErrAuditDisabled)
+	}
+
+	if req.User == nil {
+		logger.WithError(ErrNoUser).Error(ErrNoUser.Error())
+		return nil, grpcError(ctx, ErrNoUser)
+	}
+
+	if err := req.User.Validate(); err != nil {
+		logger.WithError(err).Error(ErrInvalidUser.Error())
+		return nil, grpcError(ctx, ErrInvalidUser)
+	}
+
+	customerId := req.CustomerId
+	if customerId == "" {
+		customerId = req.User.CustomerId
+	}
+
+	logger = logger.WithFields(log.Fields{
+		"customer_id": customerId,
+		"user_id":     req.User.Id,
+	})
+
+	if !hasPermission(req.User, permAuditRead) || (customerId != req.User.CustomerId && !req.User.IsOpseeAdmin()) {
+		logger.WithError(ErrPermissionDenied).Error(ErrPermissionDenied.Error())
+		return nil, grpcError(ctx, ErrPermissionDenied)
+	}
+
+	query := audit.Query{
+		CustomerId: customerId,
+		Limit:      int(req.Limit),
+	}
+
+	if req.Since != nil {
+		query.Since = time.Unix(req.Since.Seconds, int64(req.Since.Nanos)).UTC()
+	}
+
+	if req.Until != nil {
+		query.Until = time.Unix(req.Until.Seconds, int64(req.Until.Nanos)).UTC()
+
+		if query.Until.Before(query.Since) {
+			logger.WithError(ErrInvalidTimeRange).Error(ErrInvalidTimeRange.Error())
+			return nil, grpcError(ctx, ErrInvalidTimeRange)
+		}
+	}
+
+	records, err := s.auditor.store.Query(query)
+	if err != nil {
+		logger.WithError(err).Error("error querying audit records")
+		return nil, grpcError(ctx, err)
+	}
+
+	res := &QueryAuditResponse{Records: make([]*AuditRecord, len(records))}
+	for i, record := range records {
+		createdAt := &opsee_types.Timestamp{}
+		createdAt.Scan(record.CreatedAt)
+
+		res.Records[i] = &AuditRecord{
+			CustomerId:   record.CustomerId,
+			UserId:       record.UserId,
+			Caller:       record.Caller,
+			Method:       record.Method,
+			Region:       record.Region,
+			VpcId:        record.VpcId,
+			RequestType:  record.RequestType,
+			Cached:       record.Cached,
+			AWSRequestId: record.AWSRequestId,
+			Code:         record.Code,
+			CreatedAt:    createdAt,
+		}
+	}
+
+	return res, nil
+}
@@ -0,0 +1,132 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	opsee_aws_ec2 "github.com/opsee/basic/schema/aws/ec2"
+	opsee "github.com/opsee/basic/service"
+	"github.com/opsee/bezosphere/store"
+)
+
+// fakeAWS is an EC2 endpoint that answers every call with whatever respond
+// returns, a status and an XML body.
+type fakeAWS struct {
+	*httptest.Server
+
+	sync.Mutex
+	respond func(r *http.Request) (int, string)
+	calls   []string
+}
+
+func newFakeAWS(respond func(r *http.Request) (int, string)) *fakeAWS {
+	f := &fakeAWS{respond: respond}
+
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+
+		f.Lock()
+		f.calls = append(f.calls, r.Form.Get("Action"))
+		respond := f.respond
+		f.Unlock()
+
+		status, body := respond(r)
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}))
+
+	return f
+}
+
+// answer replaces how the endpoint responds.
+func (f *fakeAWS) answer(respond func(r *http.Request) (int, string)) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.respond = respond
+}
+
+// requests is how many calls the endpoint has had.
+func (f *fakeAWS) requests() int {
+	f.Lock()
+	defer f.Unlock()
+
+	return len(f.calls)
+}
+
+func (f *fakeAWS) session() *session.Session {
+	return session.New(&aws.Config{
+		Region:      aws.String("us-west-2"),
+		Endpoint:    aws.String(f.URL),
+		Credentials: credentials.NewStaticCredentials("key", "secret", ""),
+		MaxRetries:  aws.Int(0),
+	})
+}
+
+// vpcs answers DescribeVpcs with vpcIds.
+func vpcs(vpcIds ...string) func(*http.Request) (int, string) {
+	return func(*http.Request) (int, string) {
+		items := make([]string, len(vpcIds))
+		for i, vpcId := range vpcIds {
+			items[i] = "<item><vpcId>" + vpcId + "</vpcId></item>"
+		}
+
+		return http.StatusOK, `<DescribeVpcsResponse xmlns="http://ec2.amazonaws.com/doc/2015-10-01/">` +
+			`<requestId>request-1</requestId><vpcSet>` + strings.Join(items, "") + `</vpcSet></DescribeVpcsResponse>`
+	}
+}
+
+// awsError answers with an EC2 error.
+func awsError(status int, code string) func(*http.Request) (int, string) {
+	return func(*http.Request) (int, string) {
+		return status, `<Response><Errors><Error><Code>` + code + `</Code><Message>failed</Message></Error></Errors>` +
+			`<RequestId>request-1</RequestId></Response>`
+	}
+}
+
+// fakeService is a service whose sessions all talk to fake.
+func fakeService(t *testing.T, fake *fakeAWS) *service {
+	svc, err := New(Config{
+		SpanxAddress:  "localhost:9095",
+		Db:            store.NewMemory(0, nil),
+		WatchInterval: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc.sessions.sessions[sessionKey{customerId: "customer", region: "us-west-2"}] = &pooledSession{
+		session:  fake.session(),
+		lastUsed: time.Now(),
+	}
+
+	return svc
+}
+
+func vpcsRequest() *opsee.BezosRequest {
+	return &opsee.BezosRequest{
+		User:   testUser(),
+		Region: "us-west-2",
+		VpcId:  "vpc-1",
+		Input: &opsee.BezosRequest_Ec2_DescribeVpcsInput{
+			Ec2_DescribeVpcsInput: &opsee_aws_ec2.DescribeVpcsInput{},
+		},
+	}
+}
+
+// vpcIds lists the vpcs in a DescribeVpcs response.
+func vpcIds(response *opsee.BezosResponse) []string {
+	var ids []string
+	for _, vpc := range response.GetEc2_DescribeVpcsOutput().Vpcs {
+		ids = append(ids, aws.StringValue(vpc.VpcId))
+	}
+	return ids
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	opsee "github.com/opsee/basic/service"
+	log "github.com/opsee/logrus"
+	"golang.org/x/net/context"
+)
+
+const (
+	DefaultBatchConcurrency = 4
+	DefaultMaxBatchSize     = 32
+)
+
+var (
+	ErrNoBatchRequests = errors.New("batch requires at least one request, but none were given.")
+	ErrBatchTooLarge   = errors.New("batch has too many requests.")
+)
+
+// BatchGet resolves every request in the batch concurrently, sharing a single
+// AWS session between the items that miss the cache. An item failing doesn't
+// fail the batch, its error is returned in its result instead.
+func (s *service) BatchGet(ctx context.Context, req *BatchGetRequest) (*BatchGetResponse, error) {
+	logger := log.WithFields(log.Fields{
+		"batch_size": len(req.Requests),
+		"caller":     callerFromContext(ctx),
+	})
+
+	if len(req.Requests) == 0 {
+		logger.WithError(ErrNoBatchRequests).Error(ErrNoBatchRequests.Error())
+		return nil, grpcError(ctx, ErrNoBatchRequests)
+	}
+
+	if len(req.Requests) > s.maxBatchSize {
+		logger.WithError(ErrBatchTooLarge).Error(ErrBatchTooLarge.Error())
+		return nil, grpcError(ctx, ErrBatchTooLarge)
+	}
+
+	if err := validateScope(logger, req.User, req.Region, req.VpcId); err != nil {
+		return nil, grpcError(ctx, err)
+	}
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	newSession := func() *session.Session {
+		return s.sessions.get(req.User, req.Region)
+	}
+
+	var (
+		results = make([]*BatchGetResult, len(req.Requests))
+		sem     = make(chan struct{}, s.batchConcurrency)
+		wg      sync.WaitGroup
+	)
+
+	opts := requestOptions(ctx)
+
+	for i, item := range req.Requests {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(i int, item *opsee.BezosRequest) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			results[i] = s.batchItem(ctx, newSession, req, item, opts)
+		}(i, item)
+	}
+
+	wg.Wait()
+
+	return &BatchGetResponse{Results: results}, nil
+}
+
+func (s *service) batchItem(ctx context.Context, newSession sessionFunc, batch *BatchGetRequest, item *opsee.BezosRequest, opts options) *BatchGetResult {
+	if item == nil {
+		return batchError(ErrNoInput)
+	}
+
+	req := &opsee.BezosRequest{
+		User:   batch.User,
+		Region: batch.Region,
+		VpcId:  batch.VpcId,
+		MaxAge: item.MaxAge,
+		Input:  item.Input,
+	}
+
+	logger, err := validateRequest(ctx, req)
+	if err != nil {
+		return batchError(err)
+	}
+
+	res, err := s.resolve(ctx, logger, newSession, req, opts)
+	s.metrics.request("BatchGet", req, err)
+	s.audit(ctx, "BatchGet", req, opts, res, err)
+	if err != nil {
+		return batchError(err)
+	}
+
+	return &BatchGetResult{
+		Response:     res.response,
+		Cached:       res.cached,
+		AWSRequestId: res.meta.AWSRequestId,
+		Stale:        res.meta.Stale,
+	}
+}
+
+// batchError reports an item's error the way Get would have, with its AWS
+// error code and request id in the result rather than in trailers.
+func batchError(err error) *BatchGetResult {
+	status := statusOf(err)
+
+	return &BatchGetResult{
+		Error:        err.Error(),
+		Code:         uint32(status.code),
+		AWSErrorCode: status.awsCode,
+		AWSRequestId: status.requestId,
+	}
+}
@@ -0,0 +1,263 @@
+package service
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	opsee_aws_ec2 "github.com/opsee/basic/schema/aws/ec2"
+	opsee "github.com/opsee/basic/service"
+	"github.com/opsee/spanx/spanxcreds"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// failingProvider is a credentials provider that never has credentials, like
+// spanx being down.
+type failingProvider struct {
+	err error
+}
+
+func (p *failingProvider) Retrieve() (credentials.Value, error) {
+	return credentials.Value{}, p.err
+}
+
+func (p *failingProvider) IsExpired() bool {
+	return true
+}
+
+func batchRequest(items ...*opsee.BezosRequest) *BatchGetRequest {
+	return &BatchGetRequest{
+		User:     testUser(),
+		Region:   "us-west-2",
+		VpcId:    "vpc-1",
+		Requests: items,
+	}
+}
+
+// describeVpc is a batch item asking for one vpc.
+func describeVpc(vpcId string) *opsee.BezosRequest {
+	return &opsee.BezosRequest{
+		Input: &opsee.BezosRequest_Ec2_DescribeVpcsInput{
+			Ec2_DescribeVpcsInput: &opsee_aws_ec2.DescribeVpcsInput{VpcIds: []string{vpcId}},
+		},
+	}
+}
+
+func TestBatchGetSize(t *testing.T) {
+	fake := newFakeAWS(vpcs("vpc-1"))
+	defer fake.Close()
+
+	s := fakeService(t, fake)
+
+	tooMany := make([]*opsee.BezosRequest, DefaultMaxBatchSize+1)
+	for i := range tooMany {
+		tooMany[i] = describeVpc("vpc-1")
+	}
+
+	for _, test := range []struct {
+		name  string
+		items []*opsee.BezosRequest
+		code  codes.Code
+	}{
+		{
+			name: "no requests",
+			code: codes.InvalidArgument,
+		},
+		{
+			name:  "too many requests",
+			items: tooMany,
+			code:  codes.InvalidArgument,
+		},
+		{
+			name:  "as many as allowed",
+			items: tooMany[:DefaultMaxBatchSize],
+			code:  codes.OK,
+		},
+	} {
+		res, err := s.BatchGet(context.Background(), batchRequest(test.items...))
+		if code := grpc.Code(err); code != test.code {
+			t.Errorf("%s: expected %s, got %s (%v)", test.name, test.code, code, err)
+			continue
+		}
+
+		if err == nil && len(res.Results) != len(test.items) {
+			t.Errorf("%s: expected %d results, got %d", test.name, len(test.items), len(res.Results))
+		}
+	}
+
+	if n := fake.requests(); n != 1 {
+		t.Errorf("expected identical items to share 1 request, got %d", n)
+	}
+}
+
+func TestBatchGetItemErrors(t *testing.T) {
+	fake := newFakeAWS(func(r *http.Request) (int, string) {
+		if r.Form.Get("VpcId.1") == "vpc-missing" {
+			return awsError(http.StatusBadRequest, "InvalidVpcID.NotFound")(r)
+		}
+		return vpcs(r.Form.Get("VpcId.1"))(r)
+	})
+	defer fake.Close()
+
+	s := fakeService(t, fake)
+
+	res, err := s.BatchGet(context.Background(), batchRequest(describeVpc("vpc-1"), describeVpc("vpc-missing"), nil))
+	if err != nil {
+		t.Fatalf("expected item errors not to fail the batch, got %v", err)
+	}
+
+	if len(res.Results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(res.Results))
+	}
+
+	found := res.Results[0]
+	if found.Error != "" || found.Code != uint32(codes.OK) {
+		t.Errorf("expected the first item to succeed, got %s: %s", codes.Code(found.Code), found.Error)
+	} else if ids := vpcIds(found.Response); len(ids) != 1 || ids[0] != "vpc-1" {
+		t.Errorf("expected [vpc-1], got %v", ids)
+	}
+
+	for _, test := range []struct {
+		name      string
+		result    *BatchGetResult
+		code      codes.Code
+		awsCode   string
+		requestId string
+	}{
+		{
+			name:      "aws error",
+			result:    res.Results[1],
+			code:      codes.NotFound,
+			awsCode:   "InvalidVpcID.NotFound",
+			requestId: "request-1",
+		},
+		{
+			name:   "no input",
+			result: res.Results[2],
+			code:   codes.InvalidArgument,
+		},
+	} {
+		if test.result.Response != nil {
+			t.Errorf("%s: expected no response, got %v", test.name, test.result.Response)
+		}
+
+		if test.result.Error == "" {
+			t.Errorf("%s: expected an error", test.name)
+		}
+
+		if code := codes.Code(test.result.Code); code != test.code {
+			t.Errorf("%s: expected %s, got %s", test.name, test.code, code)
+		}
+
+		if test.result.AWSErrorCode != test.awsCode {
+			t.Errorf("%s: expected aws error code %q, got %q", test.name, test.awsCode, test.result.AWSErrorCode)
+		}
+
+		if test.result.AWSRequestId != test.requestId {
+			t.Errorf("%s: expected aws request id %q, got %q", test.name, test.requestId, test.result.AWSRequestId)
+		}
+	}
+}
+
+func TestBatchGetOrder(t *testing.T) {
+	const items = 12
+
+	var (
+		mu          sync.Mutex
+		active, max int
+	)
+
+	// later items are answered sooner, so they finish out of order
+	fake := newFakeAWS(func(r *http.Request) (int, string) {
+		mu.Lock()
+		active++
+		if active > max {
+			max = active
+		}
+		mu.Unlock()
+
+		vpcId := r.Form.Get("VpcId.1")
+		time.Sleep(time.Duration(items-indexOf(vpcId)) * time.Millisecond)
+
+		mu.Lock()
+		active--
+		mu.Unlock()
+
+		return vpcs(vpcId)(r)
+	})
+	defer fake.Close()
+
+	s := fakeService(t, fake)
+
+	req := batchRequest()
+	for i := 0; i < items; i++ {
+		req.Requests = append(req.Requests, describeVpc(vpcIdOf(i)))
+	}
+
+	res, err := s.BatchGet(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, result := range res.Results {
+		if result.Error != "" {
+			t.Errorf("item %d: %s", i, result.Error)
+			continue
+		}
+
+		if ids := vpcIds(result.Response); len(ids) != 1 || ids[0] != vpcIdOf(i) {
+			t.Errorf("item %d: expected [%s], got %v", i, vpcIdOf(i), ids)
+		}
+	}
+
+	if max > DefaultBatchConcurrency {
+		t.Errorf("expected at most %d items in flight, got %d", DefaultBatchConcurrency, max)
+	}
+}
+
+func TestBatchGetSessionFailure(t *testing.T) {
+	fake := newFakeAWS(vpcs("vpc-1"))
+	defer fake.Close()
+
+	s := fakeService(t, fake)
+
+	s.sessions.sessions[sessionKey{customerId: "customer", region: "us-west-2"}].session = session.New(fake.session().Config.Copy().
+		WithCredentials(credentials.NewCredentials(&failingProvider{err: spanxcreds.ErrSpanxCredentialsEmpty})))
+
+	res, err := s.BatchGet(context.Background(), batchRequest(describeVpc("vpc-1"), describeVpc("vpc-2"), describeVpc("vpc-3")))
+	if err != nil {
+		t.Fatalf("expected a session failure not to fail the batch, got %v", err)
+	}
+
+	for i, result := range res.Results {
+		if result.Response != nil || result.Error == "" {
+			t.Errorf("item %d: expected the session's error, got %v", i, result)
+		}
+
+		if code := codes.Code(result.Code); code != codes.Unavailable {
+			t.Errorf("item %d: expected %s, got %s", i, codes.Unavailable, code)
+		}
+
+		if result.AWSErrorCode != "EmptySpanxCreds" {
+			t.Errorf("item %d: expected aws error code EmptySpanxCreds, got %q", i, result.AWSErrorCode)
+		}
+	}
+
+	if n := fake.requests(); n != 0 {
+		t.Errorf("expected no requests without credentials, got %d", n)
+	}
+}
+
+// vpcIdOf names the vpc item i asks for, and indexOf gets i back.
+func vpcIdOf(i int) string {
+	return "vpc-" + string('a'+rune(i))
+}
+
+func indexOf(vpcId string) int {
+	return int(vpcId[len(vpcId)-1] - 'a')
+}
@@ -0,0 +1,186 @@
+package service
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+const (
+	DefaultBreakerThreshold = 5
+	DefaultBreakerCooldown  = time.Minute
+)
+
+var (
+	ErrCircuitOpen = errors.New("AWS credentials for this customer and region keep failing, try again later.")
+)
+
+// breakerCodes are the AWS and spanx error codes that mean a customer's
+// credentials don't work, and won't until they fix their role. Failing to
+// reach spanx isn't one of them: it says nothing about the customer, and
+// counting it would open every breaker at once when spanx is down.
+var breakerCodes = map[string]bool{
+	"AccessDenied":          true,
+	"AccessDeniedException": true,
+	"UnauthorizedOperation": true,
+	"AuthFailure":           true,
+	"InvalidClientTokenId":  true,
+	"EmptySpanxCreds":       true,
+	"NoCredentialProviders": true,
+}
+
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+// breakers keeps a circuit breaker for every customer and region. A breaker
+// opens after threshold credential failures in a row, and then turns away
+// requests until cooldown has passed. After that one request at a time is
+// let through to probe AWS, a success closes the breaker and another
+// credential failure opens it again.
+type breakers struct {
+	sync.Mutex
+
+	threshold int
+	cooldown  time.Duration
+	breakers  map[sessionKey]*breaker
+}
+
+type breaker struct {
+	state     string
+	failures  int
+	openedAt  time.Time
+	lastError string
+	probing   bool
+}
+
+// BreakerState is a breaker as shown on the debug endpoint.
+type BreakerState struct {
+	CustomerId string    `json:"customer_id"`
+	Region     string    `json:"region"`
+	State      string    `json:"state"`
+	Failures   int       `json:"failures"`
+	OpenedAt   time.Time `json:"opened_at"`
+	LastError  string    `json:"last_error"`
+}
+
+func newBreakers(threshold int, cooldown time.Duration) *breakers {
+	return &breakers{
+		threshold: threshold,
+		cooldown:  cooldown,
+		breakers:  make(map[sessionKey]*breaker),
+	}
+}
+
+// allow returns false if requests for a customer in a region should be
+// turned away without calling AWS.
+func (b *breakers) allow(customerId, region string) bool {
+	b.Lock()
+	defer b.Unlock()
+
+	br, ok := b.breakers[sessionKey{customerId: customerId, region: region}]
+	if !ok {
+		return true
+	}
+
+	switch br.state {
+	case breakerOpen:
+		if time.Since(br.openedAt) < b.cooldown {
+			return false
+		}
+
+		br.state = breakerHalfOpen
+		br.probing = true
+		return true
+
+	case breakerHalfOpen:
+		if br.probing {
+			return false
+		}
+
+		br.probing = true
+		return true
+	}
+
+	return true
+}
+
+// record updates a customer's breaker with the outcome of a call to AWS.
+// Errors that have nothing to do with credentials don't count either way.
+func (b *breakers) record(customerId, region string, err error) {
+	b.Lock()
+	defer b.Unlock()
+
+	key := sessionKey{customerId: customerId, region: region}
+	br, ok := b.breakers[key]
+
+	if err == nil {
+		if ok {
+			delete(b.breakers, key)
+		}
+		return
+	}
+
+	if !isCredentialFailure(err) {
+		if ok {
+			br.probing = false
+		}
+		return
+	}
+
+	if !ok {
+		br = &breaker{state: breakerClosed}
+		b.breakers[key] = br
+	}
+
+	br.failures++
+	br.lastError = err.Error()
+	br.probing = false
+
+	if br.state == breakerHalfOpen || br.failures >= b.threshold {
+		br.state = breakerOpen
+		br.openedAt = time.Now()
+	}
+}
+
+// states returns every breaker that isn't closed and clean.
+func (b *breakers) states() []BreakerState {
+	b.Lock()
+	defer b.Unlock()
+
+	states := make([]BreakerState, 0, len(b.breakers))
+	for key, br := range b.breakers {
+		states = append(states, BreakerState{
+			CustomerId: key.customerId,
+			Region:     key.region,
+			State:      br.state,
+			Failures:   br.failures,
+			OpenedAt:   br.openedAt,
+			LastError:  br.lastError,
+		})
+	}
+
+	sort.Sort(byCustomerRegion(states))
+	return states
+}
+
+type byCustomerRegion []BreakerState
+
+func (s byCustomerRegion) Len() int      { return len(s) }
+func (s byCustomerRegion) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byCustomerRegion) Less(i, j int) bool {
+	if s[i].CustomerId != s[j].CustomerId {
+		return s[i].CustomerId < s[j].CustomerId
+	}
+	return s[i].Region < s[j].Region
+}
+
+func isCredentialFailure(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+	return ok && breakerCodes[awsErr.Code()]
+}
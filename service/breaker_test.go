@@ -0,0 +1,192 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	log "github.com/opsee/logrus"
+	"github.com/opsee/spanx/spanxcreds"
+	"golang.org/x/net/context"
+)
+
+var (
+	credentialFailure = awserr.New("AuthFailure", "bad credentials", nil)
+	otherFailure      = awserr.New("InvalidParameterValue", "bad request", nil)
+)
+
+// cool pretends a breaker opened longer ago than its cooldown.
+func cool(b *breakers, customerId, region string) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.breakers[sessionKey{customerId: customerId, region: region}].openedAt = time.Now().Add(-2 * b.cooldown)
+}
+
+func TestBreakerOpens(t *testing.T) {
+	b := newBreakers(3, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		b.record("customer", "us-west-2", credentialFailure)
+		if !b.allow("customer", "us-west-2") {
+			t.Fatalf("breaker opened after %d failures", i+1)
+		}
+	}
+
+	b.record("customer", "us-west-2", credentialFailure)
+	if b.allow("customer", "us-west-2") {
+		t.Fatal("expected the breaker to open at the threshold")
+	}
+
+	if !b.allow("customer", "us-east-1") || !b.allow("other", "us-west-2") {
+		t.Error("expected other customers and regions to be unaffected")
+	}
+}
+
+func TestBreakerIgnoresOtherErrors(t *testing.T) {
+	b := newBreakers(1, time.Minute)
+
+	b.record("customer", "us-west-2", otherFailure)
+	b.record("customer", "us-west-2", ErrRateLimited)
+	b.record("customer", "us-west-2", spanxcreds.ErrSpanxGetCredentialsRequestFailed)
+	b.record("customer", "us-west-2", spanxcreds.ErrSpanxConnectionFailed)
+
+	if !b.allow("customer", "us-west-2") {
+		t.Error("expected errors that aren't credential failures not to count")
+	}
+
+	if states := b.states(); len(states) != 0 {
+		t.Errorf("expected no breakers, got %#v", states)
+	}
+}
+
+func TestBreakerSuccessResets(t *testing.T) {
+	b := newBreakers(2, time.Minute)
+
+	b.record("customer", "us-west-2", credentialFailure)
+	b.record("customer", "us-west-2", nil)
+	b.record("customer", "us-west-2", credentialFailure)
+
+	if !b.allow("customer", "us-west-2") {
+		t.Error("expected a success to reset the failure count")
+	}
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	b := newBreakers(1, time.Minute)
+
+	b.record("customer", "us-west-2", credentialFailure)
+	if b.allow("customer", "us-west-2") {
+		t.Fatal("expected the breaker to be open")
+	}
+
+	cool(b, "customer", "us-west-2")
+
+	if !b.allow("customer", "us-west-2") {
+		t.Fatal("expected a probe after the cooldown")
+	}
+
+	if b.allow("customer", "us-west-2") {
+		t.Fatal("expected one probe at a time")
+	}
+
+	if state := b.states()[0].State; state != breakerHalfOpen {
+		t.Errorf("expected %s, got %s", breakerHalfOpen, state)
+	}
+
+	b.record("customer", "us-west-2", nil)
+
+	if !b.allow("customer", "us-west-2") || !b.allow("customer", "us-west-2") {
+		t.Error("expected a successful probe to close the breaker")
+	}
+
+	if states := b.states(); len(states) != 0 {
+		t.Errorf("expected no breakers, got %#v", states)
+	}
+}
+
+func TestBreakerProbeReleased(t *testing.T) {
+	b := newBreakers(1, time.Minute)
+
+	b.record("customer", "us-west-2", credentialFailure)
+	cool(b, "customer", "us-west-2")
+
+	if !b.allow("customer", "us-west-2") {
+		t.Fatal("expected a probe after the cooldown")
+	}
+
+	// the rate limiter turned the probe away before it reached AWS
+	b.record("customer", "us-west-2", ErrRateLimited)
+
+	if !b.allow("customer", "us-west-2") {
+		t.Error("expected the probe to be released for the next request")
+	}
+}
+
+func TestBreakerProbeFails(t *testing.T) {
+	b := newBreakers(3, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		b.record("customer", "us-west-2", credentialFailure)
+	}
+	cool(b, "customer", "us-west-2")
+
+	if !b.allow("customer", "us-west-2") {
+		t.Fatal("expected a probe after the cooldown")
+	}
+
+	// one failed probe is enough, whatever the threshold
+	b.record("customer", "us-west-2", credentialFailure)
+
+	if b.allow("customer", "us-west-2") {
+		t.Error("expected a failed probe to reopen the breaker")
+	}
+
+	state := b.states()[0]
+	if state.State != breakerOpen || state.Failures != 4 || state.LastError == "" {
+		t.Errorf("unexpected state: %#v", state)
+	}
+}
+
+func TestBreakerStates(t *testing.T) {
+	b := newBreakers(1, time.Minute)
+
+	b.record("b", "us-west-2", credentialFailure)
+	b.record("a", "us-west-2", credentialFailure)
+	b.record("a", "us-east-1", credentialFailure)
+
+	states := b.states()
+	if len(states) != 3 {
+		t.Fatalf("expected 3 breakers, got %d", len(states))
+	}
+
+	if states[0].CustomerId != "a" || states[0].Region != "us-east-1" ||
+		states[1].CustomerId != "a" || states[1].Region != "us-west-2" ||
+		states[2].CustomerId != "b" {
+		t.Errorf("expected breakers sorted by customer and region, got %#v", states)
+	}
+}
+
+func TestBreakerIgnoresSpanxOutage(t *testing.T) {
+	fake := newFakeAWS(vpcs("vpc-1"))
+	defer fake.Close()
+
+	s := fakeService(t, fake)
+	s.breakers = newBreakers(1, time.Minute)
+
+	sess := session.New(fake.session().Config.Copy().
+		WithCredentials(credentials.NewCredentials(&failingProvider{err: spanxcreds.ErrSpanxGetCredentialsRequestFailed})))
+
+	for i := 0; i < 3; i++ {
+		_, err := s.resolve(context.Background(), log.WithField("test", "spanx outage"), func() *session.Session { return sess }, vpcsRequest(), options{})
+		if err != spanxcreds.ErrSpanxGetCredentialsRequestFailed {
+			t.Fatalf("attempt %d: expected spanx's error, got %v", i+1, err)
+		}
+	}
+
+	if states := s.breakers.states(); len(states) != 0 {
+		t.Errorf("expected spanx being down not to open breakers, got %#v", states)
+	}
+}
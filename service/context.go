@@ -0,0 +1,68 @@
+package service
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"golang.org/x/net/context"
+)
+
+const (
+	DefaultMaxRequestTimeout = 30 * time.Second
+)
+
+// withTimeout bounds a request by the server's max request timeout. A client
+// deadline that's sooner still wins.
+func (s *service) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, s.maxRequestTimeout)
+}
+
+// withContext ties an AWS request to ctx. Every attempt is cancelled when ctx
+// is done, as is the sleep between retries, and once it's done the request
+// fails with ctx's error rather than retrying. The handlers are copied to any
+// further pages of the request.
+func withContext(ctx context.Context, awsRequest *request.Request) {
+	awsRequest.Handlers.Send.PushFront(func(r *request.Request) {
+		// retries build a new http request, so this is set on every attempt
+		r.HTTPRequest.Cancel = ctx.Done()
+	})
+
+	awsRequest.Handlers.Retry.PushBack(func(r *request.Request) {
+		if err := ctx.Err(); err != nil {
+			r.Error = err
+			r.Retryable = aws.Bool(false)
+		}
+	})
+
+	awsRequest.Config.SleepDelay = func(delay time.Duration) {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+	}
+}
+
+// awaitCredentials gets an AWS request's credentials, or gives up when ctx is
+// done. Pooled credentials are refreshed detached from any one request, see
+// spanxProvider, so the refresh carries on for whoever needs them next.
+func awaitCredentials(ctx context.Context, awsRequest *request.Request) error {
+	done := make(chan error, 1)
+	go func() {
+		_, err := awsRequest.Config.Credentials.Get()
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// isCancellation reports whether err is a caller going away or running out
+// of time, rather than something failing.
+func isCancellation(err error) bool {
+	return err == context.Canceled || err == context.DeadlineExceeded
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"golang.org/x/net/context"
+)
+
+// blockingProvider is a credentials provider that doesn't answer until it's
+// released, like spanx having a bad day.
+type blockingProvider struct {
+	release chan struct{}
+}
+
+func (p *blockingProvider) Retrieve() (credentials.Value, error) {
+	<-p.release
+	return credentials.Value{AccessKeyID: "key", SecretAccessKey: "secret"}, nil
+}
+
+func (p *blockingProvider) IsExpired() bool {
+	return true
+}
+
+func TestAwaitCredentialsCancelled(t *testing.T) {
+	provider := &blockingProvider{release: make(chan struct{})}
+	defer close(provider.release)
+
+	awsRequest := &request.Request{Config: aws.Config{Credentials: credentials.NewCredentials(provider)}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := awaitCredentials(ctx, awsRequest); err != context.DeadlineExceeded {
+		t.Errorf("expected the request to give up waiting, got %v", err)
+	}
+}
+
+func TestAwaitCredentials(t *testing.T) {
+	provider := &blockingProvider{release: make(chan struct{})}
+	close(provider.release)
+
+	awsRequest := &request.Request{Config: aws.Config{Credentials: credentials.NewCredentials(provider)}}
+
+	if err := awaitCredentials(context.Background(), awsRequest); err != nil {
+		t.Error(err)
+	}
+}
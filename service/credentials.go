@@ -0,0 +1,82 @@
+package service
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/opsee/basic/schema"
+	opsee "github.com/opsee/basic/service"
+	log "github.com/opsee/logrus"
+	"github.com/opsee/spanx/spanxcreds"
+	"golang.org/x/net/context"
+)
+
+// spanxProvider gets a customer's AWS credentials from spanx, the same way
+// spanxcreds.SpanxProvider does, but bounds how long it'll wait on spanx.
+// Pooled credentials are shared by every request for a customer, so a refresh
+// isn't tied to whichever request happened to trigger it. The requests waiting
+// on it give up when they're cancelled, see awaitCredentials.
+type spanxProvider struct {
+	credentials.Expiry
+
+	user    *schema.User
+	client  opsee.SpanxClient
+	timeout time.Duration
+	metrics *serviceMetrics
+
+	// ExpiryWindow refreshes credentials this long before they actually
+	// expire, so they don't expire between signing and sending a request.
+	ExpiryWindow time.Duration
+}
+
+func newSpanxCredentials(user *schema.User, client opsee.SpanxClient, timeout, expiryWindow time.Duration, metrics *serviceMetrics) *credentials.Credentials {
+	return credentials.NewCredentials(&spanxProvider{
+		user:         user,
+		client:       client,
+		timeout:      timeout,
+		metrics:      metrics,
+		ExpiryWindow: expiryWindow,
+	})
+}
+
+func (p *spanxProvider) Retrieve() (credentials.Value, error) {
+	value := credentials.Value{ProviderName: spanxcreds.SpanxProviderName}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	start := time.Now()
+	resp, err := p.client.GetCredentials(ctx, &opsee.GetCredentialsRequest{User: p.user})
+	p.metrics.spanxDuration.Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			p.metrics.spanxFailures.Inc(spanxTimeout)
+			log.WithError(ctxErr).Warn("spanx credentials request timed out")
+			return value, ctxErr
+		}
+
+		p.metrics.spanxFailures.Inc(spanxError)
+		log.WithError(err).Error("couldn't get spanx credentials")
+		return value, spanxcreds.ErrSpanxGetCredentialsRequestFailed
+	}
+
+	creds := resp.GetCredentials()
+	if creds == nil {
+		p.metrics.spanxFailures.Inc(spanxEmpty)
+		return value, spanxcreds.ErrSpanxCredentialsEmpty
+	}
+
+	value.AccessKeyID = aws.StringValue(creds.AccessKeyID)
+	value.SecretAccessKey = aws.StringValue(creds.SecretAccessKey)
+	value.SessionToken = aws.StringValue(creds.SessionToken)
+
+	if resp.Expires != nil {
+		if expires, err := resp.Expires.Value(); err == nil {
+			p.SetExpiration(expires.(time.Time), p.ExpiryWindow)
+		}
+	}
+
+	return value, nil
+}
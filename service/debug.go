@@ -0,0 +1,28 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+
+	log "github.com/opsee/logrus"
+)
+
+// StartDebug serves debugging endpoints over plain http. It's meant for
+// operators, so listenAddr shouldn't be reachable by clients.
+func (s *service) StartDebug(listenAddr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/breakers", s.debugBreakers)
+
+	log.Infof("starting debug server at %s", listenAddr)
+	return http.ListenAndServe(listenAddr, mux)
+}
+
+// debugBreakers lists every circuit breaker that has seen a credential
+// failure since it last closed.
+func (s *service) debugBreakers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(s.breakers.states()); err != nil {
+		log.WithError(err).Error("error writing breaker states")
+	}
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	log "github.com/opsee/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// AWSErrorCodeTrailer carries the AWS error code a request failed with,
+	// e.g. "Throttling". The AWS request id goes in RequestIdTrailer.
+	AWSErrorCodeTrailer = "bezosphere-aws-error-code"
+)
+
+// errorCodes maps our own errors to the status a client should see.
+var errorCodes = map[error]codes.Code{
+	ErrNoInput:             codes.InvalidArgument,
+	ErrNoRegion:            codes.InvalidArgument,
+	ErrNoVpcId:             codes.InvalidArgument,
+	ErrUnsupportedInput:    codes.InvalidArgument,
+	ErrNoBatchRequests:     codes.InvalidArgument,
+	ErrBatchTooLarge:       codes.InvalidArgument,
+	ErrInvalidTimeRange:    codes.InvalidArgument,
+	ErrNoUser:              codes.Unauthenticated,
+	ErrNoClientCertificate: codes.Unauthenticated,
+	ErrInvalidUser:         codes.PermissionDenied,
+	ErrRegionWideDenied:    codes.PermissionDenied,
+	ErrPermissionDenied:    codes.PermissionDenied,
+	ErrCallerNotAllowed:    codes.PermissionDenied,
+	ErrAuditDisabled:       codes.FailedPrecondition,
+	ErrCircuitOpen:         codes.FailedPrecondition,
+	ErrRateLimited:         codes.ResourceExhausted,
+
+	context.Canceled:         codes.Canceled,
+	context.DeadlineExceeded: codes.DeadlineExceeded,
+}
+
+// awsErrorCodes maps AWS error codes to statuses. Not found and malformed id
+// errors are matched by name instead, since every service spells them
+// differently, e.g. InvalidVpcID.NotFound and DBInstanceNotFound.
+var awsErrorCodes = map[string]codes.Code{
+	"Throttling":                       codes.ResourceExhausted,
+	"ThrottlingException":              codes.ResourceExhausted,
+	"RequestLimitExceeded":             codes.ResourceExhausted,
+	"TooManyRequestsException":         codes.ResourceExhausted,
+	"AccessDenied":                     codes.PermissionDenied,
+	"AccessDeniedException":            codes.PermissionDenied,
+	"UnauthorizedOperation":            codes.PermissionDenied,
+	"AuthFailure":                      codes.PermissionDenied,
+	"InvalidClientTokenId":             codes.PermissionDenied,
+	"SignatureDoesNotMatch":            codes.PermissionDenied,
+	"ExpiredToken":                     codes.Unauthenticated,
+	"ExpiredTokenException":            codes.Unauthenticated,
+	"InvalidParameter":                 codes.InvalidArgument,
+	"InvalidParameterValue":            codes.InvalidArgument,
+	"InvalidParameterCombination":      codes.InvalidArgument,
+	"InvalidParameterException":        codes.InvalidArgument,
+	"MissingParameter":                 codes.InvalidArgument,
+	"ValidationError":                  codes.InvalidArgument,
+	"OptInRequired":                    codes.FailedPrecondition,
+	"RequestCanceled":                  codes.Canceled,
+	"RequestError":                     codes.Unavailable,
+	"EmptySpanxCreds":                  codes.Unavailable,
+	"SpanxConnectionFailed":            codes.Unavailable,
+	"SpanxGetCredentialsRequestFailed": codes.Unavailable,
+	"NoCredentialProviders":            codes.Unavailable,
+}
+
+// errorStatus is what an error looks like to a grpc client: a status code,
+// plus the AWS error code and request id if it came from AWS.
+type errorStatus struct {
+	code      codes.Code
+	awsCode   string
+	requestId string
+}
+
+// statusOf translates an error into a grpc status. Errors that already carry
+// a status, and anything we don't recognize, keep theirs.
+func statusOf(err error) errorStatus {
+	if code, ok := errorCodes[err]; ok {
+		return errorStatus{code: code}
+	}
+
+	awsErr, ok := err.(awserr.Error)
+	if !ok {
+		return errorStatus{code: grpc.Code(err)}
+	}
+
+	status := errorStatus{
+		code:    codes.Unknown,
+		awsCode: awsErr.Code(),
+	}
+
+	if reqErr, ok := err.(awserr.RequestFailure); ok {
+		status.requestId = reqErr.RequestID()
+
+		if reqErr.StatusCode() >= 500 {
+			status.code = codes.Unavailable
+		}
+	}
+
+	if code, ok := awsErrorCodes[status.awsCode]; ok {
+		status.code = code
+	} else if strings.Contains(status.awsCode, "NotFound") {
+		status.code = codes.NotFound
+	} else if strings.HasSuffix(status.awsCode, ".Malformed") {
+		status.code = codes.InvalidArgument
+	}
+
+	return status
+}
+
+// grpcError translates an error for a grpc client, attaching the AWS error
+// code and request id as trailing metadata since the status itself only has
+// room for a message.
+func grpcError(ctx context.Context, err error) error {
+	status := statusOf(err)
+
+	if status.awsCode != "" {
+		trailerErr := grpc.SetTrailer(ctx, metadata.Pairs(
+			AWSErrorCodeTrailer, status.awsCode,
+			RequestIdTrailer, status.requestId,
+		))
+		if trailerErr != nil {
+			log.WithError(trailerErr).Warn("couldn't set error trailer")
+		}
+	}
+
+	if status.code == grpc.Code(err) {
+		return err
+	}
+
+	return grpc.Errorf(status.code, "%s", err.Error())
+}
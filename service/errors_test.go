@@ -0,0 +1,57 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+func TestStatusOf(t *testing.T) {
+	failure := func(code string, statusCode int) error {
+		return awserr.NewRequestFailure(awserr.New(code, "failed", nil), statusCode, "request-1")
+	}
+
+	tests := []struct {
+		name      string
+		err       error
+		code      codes.Code
+		awsCode   string
+		requestId string
+	}{
+		{"our own error", ErrNoVpcId, codes.InvalidArgument, "", ""},
+		{"rate limited", ErrRateLimited, codes.ResourceExhausted, "", ""},
+		{"deadline", context.DeadlineExceeded, codes.DeadlineExceeded, "", ""},
+		{"grpc status", grpc.Errorf(codes.Aborted, "aborted"), codes.Aborted, "", ""},
+		{"unknown", errors.New("something else"), codes.Unknown, "", ""},
+		{"throttled", failure("Throttling", 400), codes.ResourceExhausted, "Throttling", "request-1"},
+		{"access denied", failure("UnauthorizedOperation", 403), codes.PermissionDenied, "UnauthorizedOperation", "request-1"},
+		{"expired token", failure("ExpiredToken", 400), codes.Unauthenticated, "ExpiredToken", "request-1"},
+		{"invalid parameter", failure("InvalidParameterValue", 400), codes.InvalidArgument, "InvalidParameterValue", "request-1"},
+		{"opt in required", failure("OptInRequired", 401), codes.FailedPrecondition, "OptInRequired", "request-1"},
+		{"canceled", awserr.New("RequestCanceled", "canceled", nil), codes.Canceled, "RequestCanceled", ""},
+		{"network error", awserr.New("RequestError", "send request failed", nil), codes.Unavailable, "RequestError", ""},
+		{"unknown server error", failure("InternalError", 500), codes.Unavailable, "InternalError", "request-1"},
+		{"unknown client error", failure("SomethingNew", 400), codes.Unknown, "SomethingNew", "request-1"},
+		{"mapped code wins over 5xx", failure("Throttling", 503), codes.ResourceExhausted, "Throttling", "request-1"},
+		{"ec2 not found", failure("InvalidVpcID.NotFound", 400), codes.NotFound, "InvalidVpcID.NotFound", "request-1"},
+		{"rds not found", failure("DBInstanceNotFound", 404), codes.NotFound, "DBInstanceNotFound", "request-1"},
+		{"malformed id", failure("InvalidInstanceID.Malformed", 400), codes.InvalidArgument, "InvalidInstanceID.Malformed", "request-1"},
+		{"malformed is a suffix", failure("Malformed.Something", 400), codes.Unknown, "Malformed.Something", "request-1"},
+	}
+
+	for _, test := range tests {
+		status := statusOf(test.err)
+
+		if status.code != test.code {
+			t.Errorf("%s: expected %s, got %s", test.name, test.code, status.code)
+		}
+
+		if status.awsCode != test.awsCode || status.requestId != test.requestId {
+			t.Errorf("%s: expected aws code %q and request id %q, got %q and %q", test.name, test.awsCode, test.requestId, status.awsCode, status.requestId)
+		}
+	}
+}
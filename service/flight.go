@@ -0,0 +1,51 @@
+package service
+
+import (
+	"sync"
+)
+
+// flightGroup collapses concurrent calls with the same key into one, so a
+// burst of identical cache misses only makes one AWS request.
+type flightGroup struct {
+	sync.Mutex
+	calls map[string]*flight
+}
+
+type flight struct {
+	wg  sync.WaitGroup
+	val interface{}
+	err error
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{
+		calls: make(map[string]*flight),
+	}
+}
+
+// do calls fn, unless a call for key is already in flight, in which case it
+// waits for that call and returns its result. shared reports whether the
+// result came from another caller's call.
+func (g *flightGroup) do(key string, fn func() (interface{}, error)) (val interface{}, shared bool, err error) {
+	g.Lock()
+	if f, ok := g.calls[key]; ok {
+		g.Unlock()
+		f.wg.Wait()
+		return f.val, true, f.err
+	}
+
+	f := &flight{}
+	f.wg.Add(1)
+	g.calls[key] = f
+	g.Unlock()
+
+	defer func() {
+		g.Lock()
+		delete(g.calls, key)
+		g.Unlock()
+		f.wg.Done()
+	}()
+
+	f.val, f.err = fn()
+	return f.val, false, f.err
+}
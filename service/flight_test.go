@@ -0,0 +1,202 @@
+package service
+
+import (
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/opsee/logrus"
+	"golang.org/x/net/context"
+)
+
+func TestFlightGroupDo(t *testing.T) {
+	var (
+		g       = newFlightGroup()
+		release = make(chan struct{})
+		started = make(chan struct{})
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		calls   int
+		shares  int
+	)
+
+	fn := func() (interface{}, error) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+
+		close(started)
+		<-release
+		return "value", nil
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		v, shared, err := g.do("key", fn)
+		if v != "value" || shared || err != nil {
+			t.Errorf("expected the leader to get its own value, got %v, %v, %v", v, shared, err)
+		}
+	}()
+	<-started
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			v, shared, err := g.do("key", fn)
+			if v != "value" || err != nil {
+				t.Errorf("expected a follower to get the leader's value, got %v, %v", v, err)
+			}
+
+			if shared {
+				mu.Lock()
+				shares++
+				mu.Unlock()
+			}
+		}()
+	}
+
+	// give the followers time to join the leader's flight
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("expected concurrent calls to be coalesced into 1, got %d", calls)
+	}
+
+	if shares != 4 {
+		t.Errorf("expected 4 followers to share the leader's call, got %d", shares)
+	}
+
+	v, shared, err := g.do("key", func() (interface{}, error) {
+		return "again", nil
+	})
+	if v != "again" || shared || err != nil {
+		t.Errorf("expected a call after the flight landed to run again, got %v, %v, %v", v, shared, err)
+	}
+}
+
+// blockFirst answers like respond, but holds the first call until release is
+// closed or sent on, signalling arrived once it's come in.
+func blockFirst(respond func(*http.Request) (int, string), arrived, release chan struct{}) func(*http.Request) (int, string) {
+	var (
+		mu    sync.Mutex
+		first = true
+	)
+
+	return func(r *http.Request) (int, string) {
+		mu.Lock()
+		block := first
+		first = false
+		mu.Unlock()
+
+		if block {
+			close(arrived)
+			<-release
+		}
+		return respond(r)
+	}
+}
+
+func TestResolveCoalescesMisses(t *testing.T) {
+	arrived, release := make(chan struct{}), make(chan struct{})
+
+	fake := newFakeAWS(blockFirst(vpcs("vpc-1"), arrived, release))
+	defer fake.Close()
+	defer close(release)
+
+	s := fakeService(t, fake)
+
+	var wg sync.WaitGroup
+	resolve := func() {
+		defer wg.Done()
+
+		res, err := s.resolve(context.Background(), log.WithField("test", "coalesce"), fake.session, vpcsRequest(), options{})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if ids := vpcIds(res.response); !reflect.DeepEqual(ids, []string{"vpc-1"}) {
+			t.Errorf("expected [vpc-1], got %v", ids)
+		}
+	}
+
+	wg.Add(1)
+	go resolve()
+	<-arrived
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go resolve()
+	}
+
+	// give the misses time to join the leader's flight
+	time.Sleep(20 * time.Millisecond)
+	release <- struct{}{}
+	wg.Wait()
+
+	if n := fake.requests(); n != 1 {
+		t.Errorf("expected concurrent misses to make 1 request, got %d", n)
+	}
+}
+
+func TestResolveRetriesCancelledLeader(t *testing.T) {
+	arrived, release := make(chan struct{}), make(chan struct{})
+
+	fake := newFakeAWS(blockFirst(vpcs("vpc-1"), arrived, release))
+	defer fake.Close()
+	defer close(release)
+
+	s := fakeService(t, fake)
+
+	leaderCtx, cancel := context.WithCancel(context.Background())
+	leader := make(chan error, 1)
+	go func() {
+		_, err := s.resolve(leaderCtx, log.WithField("test", "leader"), fake.session, vpcsRequest(), options{})
+		leader <- err
+	}()
+	<-arrived
+
+	type result struct {
+		res *resolution
+		err error
+	}
+
+	follower := make(chan result, 1)
+	go func() {
+		res, err := s.resolve(context.Background(), log.WithField("test", "follower"), fake.session, vpcsRequest(), options{})
+		follower <- result{res, err}
+	}()
+
+	// give the follower time to join the leader's flight
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	if err := <-leader; err != context.Canceled {
+		t.Errorf("expected the leader to be cancelled, got %v", err)
+	}
+
+	select {
+	case r := <-follower:
+		if r.err != nil {
+			t.Fatalf("expected the follower to retry the cancelled request, got %v", r.err)
+		}
+
+		if ids := vpcIds(r.res.response); !reflect.DeepEqual(ids, []string{"vpc-1"}) {
+			t.Errorf("expected [vpc-1], got %v", ids)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("follower never returned")
+	}
+
+	if n := fake.requests(); n != 2 {
+		t.Errorf("expected the follower to make its own request, got %d requests", n)
+	}
+}
@@ -0,0 +1,189 @@
+package service
+
+import (
+	opsee "github.com/opsee/basic/service"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// BezosServer is the full opsee.Bezos service as served by bezosphere, a
+// superset of opsee.BezosServer. Clients built from bezos.proto keep working
+// against it.
+type BezosServer interface {
+	opsee.BezosServer
+	BatchGet(context.Context, *BatchGetRequest) (*BatchGetResponse, error)
+	Watch(*opsee.BezosRequest, Bezos_WatchServer) error
+	QueryAudit(context.Context, *QueryAuditRequest) (*QueryAuditResponse, error)
+}
+
+func RegisterBezosServer(s *grpc.Server, srv BezosServer) {
+	s.RegisterService(&_Bezos_serviceDesc, srv)
+}
+
+// BezosClient is a client for the full opsee.Bezos service.
+type BezosClient interface {
+	opsee.BezosClient
+	BatchGet(ctx context.Context, in *BatchGetRequest, opts ...grpc.CallOption) (*BatchGetResponse, error)
+	Watch(ctx context.Context, in *opsee.BezosRequest, opts ...grpc.CallOption) (Bezos_WatchClient, error)
+	QueryAudit(ctx context.Context, in *QueryAuditRequest, opts ...grpc.CallOption) (*QueryAuditResponse, error)
+}
+
+type bezosClient struct {
+	opsee.BezosClient
+	cc *grpc.ClientConn
+}
+
+func NewBezosClient(cc *grpc.ClientConn) BezosClient {
+	return &bezosClient{opsee.NewBezosClient(cc), cc}
+}
+
+func (c *bezosClient) BatchGet(ctx context.Context, in *BatchGetRequest, opts ...grpc.CallOption) (*BatchGetResponse, error) {
+	out := new(BatchGetResponse)
+	err := grpc.Invoke(ctx, "/opsee.Bezos/BatchGet", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bezosClient) QueryAudit(ctx context.Context, in *QueryAuditRequest, opts ...grpc.CallOption) (*QueryAuditResponse, error) {
+	out := new(QueryAuditResponse)
+	err := grpc.Invoke(ctx, "/opsee.Bezos/QueryAudit", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bezosClient) Watch(ctx context.Context, in *opsee.BezosRequest, opts ...grpc.CallOption) (Bezos_WatchClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Bezos_serviceDesc.Streams[0], c.cc, "/opsee.Bezos/Watch", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &bezosWatchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Bezos_WatchClient interface {
+	Recv() (*opsee.BezosResponse, error)
+	grpc.ClientStream
+}
+
+type bezosWatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *bezosWatchClient) Recv() (*opsee.BezosResponse, error) {
+	m := new(opsee.BezosResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Bezos_Get_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(opsee.BezosRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BezosServer).Get(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/opsee.Bezos/Get",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BezosServer).Get(ctx, req.(*opsee.BezosRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Bezos_BatchGet_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchGetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BezosServer).BatchGet(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/opsee.Bezos/BatchGet",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BezosServer).BatchGet(ctx, req.(*BatchGetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Bezos_QueryAudit_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QueryAuditRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BezosServer).QueryAudit(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/opsee.Bezos/QueryAudit",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BezosServer).QueryAudit(ctx, req.(*QueryAuditRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Bezos_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(opsee.BezosRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(BezosServer).Watch(m, &bezosWatchServer{stream})
+}
+
+type Bezos_WatchServer interface {
+	Send(*opsee.BezosResponse) error
+	grpc.ServerStream
+}
+
+type bezosWatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *bezosWatchServer) Send(m *opsee.BezosResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+var _Bezos_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "opsee.Bezos",
+	HandlerType: (*BezosServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Get",
+			Handler:    _Bezos_Get_Handler,
+		},
+		{
+			MethodName: "BatchGet",
+			Handler:    _Bezos_BatchGet_Handler,
+		},
+		{
+			MethodName: "QueryAudit",
+			Handler:    _Bezos_QueryAudit_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       _Bezos_Watch_Handler,
+			ServerStreams: true,
+		},
+	},
+}
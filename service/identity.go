@@ -0,0 +1,148 @@
+package service
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+
+	log "github.com/opsee/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	grpcauth "google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+var (
+	ErrNoClientCertificate = errors.New("request requires a client certificate, but none was given.")
+	ErrCallerNotAllowed    = errors.New("client is not allowed to call bezosphere.")
+	ErrNoClientCAs         = errors.New("client ca file has no certificates.")
+	ErrAllowlistWithoutCA  = errors.New("allowed clients require a client ca file to verify them with.")
+)
+
+type callerKey struct{}
+
+// serverCredentials returns the TLS config to serve with. With a client CA
+// file, clients have to present a certificate signed by one of its CAs.
+func serverCredentials(cert, certkey, clientCAFile string) (grpcauth.TransportCredentials, error) {
+	if clientCAFile == "" {
+		return grpcauth.NewServerTLSFromFile(cert, certkey)
+	}
+
+	keyPair, err := tls.LoadX509KeyPair(cert, certkey)
+	if err != nil {
+		return nil, err
+	}
+
+	pem, err := ioutil.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(pem) {
+		return nil, ErrNoClientCAs
+	}
+
+	return grpcauth.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{keyPair},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}), nil
+}
+
+// identities returns the names a verified client certificate was issued to,
+// its common name first, then its DNS and email subject alternative names.
+func identities(cert *x509.Certificate) []string {
+	var names []string
+
+	if cert.Subject.CommonName != "" {
+		names = append(names, cert.Subject.CommonName)
+	}
+
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+
+	return names
+}
+
+// identify returns who's calling, from the verified client certificate on
+// the connection a request came in on. With an allowlist, it's the first of
+// the certificate's names that's allowed, and callers with none are refused.
+// It returns "" if the connection has no client certificate and we aren't
+// requiring one, which we do whenever there's a client CA or an allowlist.
+func (s *service) identify(ctx context.Context) (string, error) {
+	var chains [][]*x509.Certificate
+
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(grpcauth.TLSInfo); ok {
+			chains = info.State.VerifiedChains
+		}
+	}
+
+	if len(chains) == 0 || len(chains[0]) == 0 {
+		if s.clientCAFile != "" || len(s.allowedClients) > 0 {
+			return "", ErrNoClientCertificate
+		}
+		return "", nil
+	}
+
+	names := identities(chains[0][0])
+
+	if len(s.allowedClients) == 0 {
+		if len(names) == 0 {
+			return "", nil
+		}
+		return names[0], nil
+	}
+
+	for _, name := range names {
+		if s.allowedClients[name] {
+			return name, nil
+		}
+	}
+
+	return "", ErrCallerNotAllowed
+}
+
+// unaryIdentity refuses calls from clients that aren't allowed, and puts the
+// caller's identity in the context of those that are.
+func (s *service) unaryIdentity(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	caller, err := s.identify(ctx)
+	if err != nil {
+		log.WithError(err).WithField("method", info.FullMethod).Error("refusing client")
+		return nil, grpcError(ctx, err)
+	}
+
+	return handler(context.WithValue(ctx, callerKey{}, caller), req)
+}
+
+// streamIdentity is unaryIdentity for streams.
+func (s *service) streamIdentity(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	caller, err := s.identify(stream.Context())
+	if err != nil {
+		log.WithError(err).WithField("method", info.FullMethod).Error("refusing client")
+		return grpcError(stream.Context(), err)
+	}
+
+	return handler(srv, &identifiedStream{
+		ServerStream: stream,
+		ctx:          context.WithValue(stream.Context(), callerKey{}, caller),
+	})
+}
+
+type identifiedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *identifiedStream) Context() context.Context {
+	return s.ctx
+}
+
+// callerFromContext returns the verified identity of the client that made a
+// request, or "" if it didn't present a certificate.
+func callerFromContext(ctx context.Context) string {
+	caller, _ := ctx.Value(callerKey{}).(string)
+	return caller
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/context"
+	grpcauth "google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+func clientCert(commonName string, dnsNames ...string) *x509.Certificate {
+	return &x509.Certificate{
+		Subject:        pkix.Name{CommonName: commonName},
+		DNSNames:       dnsNames,
+		EmailAddresses: []string{"ops@example.com"},
+	}
+}
+
+// peerContext is the context of a request from a client that presented cert,
+// or no certificate if it's nil.
+func peerContext(cert *x509.Certificate) context.Context {
+	var state tls.ConnectionState
+	if cert != nil {
+		state.VerifiedChains = [][]*x509.Certificate{{cert}}
+	}
+
+	return peer.NewContext(context.Background(), &peer.Peer{
+		AuthInfo: grpcauth.TLSInfo{State: state},
+	})
+}
+
+func TestIdentities(t *testing.T) {
+	names := identities(clientCert("bartnet", "bartnet.in.opsee.com"))
+	expected := []string{"bartnet", "bartnet.in.opsee.com", "ops@example.com"}
+
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v, got %v", expected, names)
+	}
+
+	names = identities(clientCert("", "bartnet.in.opsee.com"))
+	expected = []string{"bartnet.in.opsee.com", "ops@example.com"}
+
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v, got %v", expected, names)
+	}
+}
+
+func TestIdentify(t *testing.T) {
+	tests := []struct {
+		name         string
+		clientCAFile string
+		allowed      []string
+		cert         *x509.Certificate
+		caller       string
+		err          error
+	}{
+		{
+			name: "no certificate, none required",
+		},
+		{
+			name:         "no certificate with a client ca",
+			clientCAFile: "ca.pem",
+			err:          ErrNoClientCertificate,
+		},
+		{
+			name:         "no certificate with an allowlist",
+			clientCAFile: "ca.pem",
+			allowed:      []string{"bartnet"},
+			err:          ErrNoClientCertificate,
+		},
+		{
+			name:         "certificate without an allowlist",
+			clientCAFile: "ca.pem",
+			cert:         clientCert("bartnet", "bartnet.in.opsee.com"),
+			caller:       "bartnet",
+		},
+		{
+			name:         "allowed by common name",
+			clientCAFile: "ca.pem",
+			allowed:      []string{"bartnet"},
+			cert:         clientCert("bartnet", "bartnet.in.opsee.com"),
+			caller:       "bartnet",
+		},
+		{
+			name:         "allowed by dns name",
+			clientCAFile: "ca.pem",
+			allowed:      []string{"bartnet.in.opsee.com"},
+			cert:         clientCert("bartnet", "bartnet.in.opsee.com"),
+			caller:       "bartnet.in.opsee.com",
+		},
+		{
+			name:         "not allowed",
+			clientCAFile: "ca.pem",
+			allowed:      []string{"hugs"},
+			cert:         clientCert("bartnet", "bartnet.in.opsee.com"),
+			err:          ErrCallerNotAllowed,
+		},
+	}
+
+	for _, test := range tests {
+		s := &service{
+			clientCAFile:   test.clientCAFile,
+			allowedClients: make(map[string]bool),
+		}
+		for _, client := range test.allowed {
+			s.allowedClients[client] = true
+		}
+
+		caller, err := s.identify(peerContext(test.cert))
+		if err != test.err {
+			t.Errorf("%s: expected error %v, got %v", test.name, test.err, err)
+		}
+
+		if caller != test.caller {
+			t.Errorf("%s: expected caller %q, got %q", test.name, test.caller, caller)
+		}
+	}
+}
+
+func TestAllowlistRequiresClientCA(t *testing.T) {
+	if _, err := New(Config{AllowedClients: []string{"bartnet"}}); err != ErrAllowlistWithoutCA {
+		t.Errorf("expected %v, got %v", ErrAllowlistWithoutCA, err)
+	}
+}
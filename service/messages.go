@@ -0,0 +1,96 @@
+package service
+
+import (
+	"github.com/gogo/protobuf/proto"
+	"github.com/opsee/basic/schema"
+	opsee "github.com/opsee/basic/service"
+	opsee_types "github.com/opsee/protobuf/opseeproto/types"
+)
+
+// The messages in this file extend the opsee.Bezos service defined in
+// github.com/opsee/basic/service/bezos.proto. They're written by hand with
+// protobuf struct tags rather than generated, and are encoded by reflection.
+
+// BatchGetRequest fetches several AWS descriptions in one round trip. Every
+// item is resolved for the batch's user, region and vpc, those fields on the
+// items themselves are ignored.
+type BatchGetRequest struct {
+	User     *schema.User          `protobuf:"bytes,1,opt,name=user" json:"user,omitempty"`
+	Region   string                `protobuf:"bytes,2,opt,name=region,proto3" json:"region,omitempty"`
+	VpcId    string                `protobuf:"bytes,3,opt,name=vpc_id,json=vpcId,proto3" json:"vpc_id,omitempty"`
+	Requests []*opsee.BezosRequest `protobuf:"bytes,4,rep,name=requests" json:"requests,omitempty"`
+}
+
+func (m *BatchGetRequest) Reset()         { *m = BatchGetRequest{} }
+func (m *BatchGetRequest) String() string { return proto.CompactTextString(m) }
+func (*BatchGetRequest) ProtoMessage()    {}
+
+// BatchGetResponse holds one result per request, in request order.
+type BatchGetResponse struct {
+	Results []*BatchGetResult `protobuf:"bytes,1,rep,name=results" json:"results,omitempty"`
+}
+
+func (m *BatchGetResponse) Reset()         { *m = BatchGetResponse{} }
+func (m *BatchGetResponse) String() string { return proto.CompactTextString(m) }
+func (*BatchGetResponse) ProtoMessage()    {}
+
+// BatchGetResult is either a response or an error for a single item. Code is
+// the grpc status code the item would have failed with on its own, and
+// AWSErrorCode the AWS error code behind it, if any.
+type BatchGetResult struct {
+	Response     *opsee.BezosResponse `protobuf:"bytes,1,opt,name=response" json:"response,omitempty"`
+	Error        string               `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+	Code         uint32               `protobuf:"varint,3,opt,name=code,proto3" json:"code,omitempty"`
+	Cached       bool                 `protobuf:"varint,4,opt,name=cached,proto3" json:"cached,omitempty"`
+	AWSRequestId string               `protobuf:"bytes,5,opt,name=aws_request_id,json=awsRequestId,proto3" json:"aws_request_id,omitempty"`
+	Stale        bool                 `protobuf:"varint,6,opt,name=stale,proto3" json:"stale,omitempty"`
+	AWSErrorCode string               `protobuf:"bytes,7,opt,name=aws_error_code,json=awsErrorCode,proto3" json:"aws_error_code,omitempty"`
+}
+
+func (m *BatchGetResult) Reset()         { *m = BatchGetResult{} }
+func (m *BatchGetResult) String() string { return proto.CompactTextString(m) }
+func (*BatchGetResult) ProtoMessage()    {}
+
+// QueryAuditRequest asks for a customer's audit records created in
+// [since, until), newest first. CustomerId defaults to the user's own
+// customer, only Opsee admins can query another's. An unset until means now.
+type QueryAuditRequest struct {
+	User       *schema.User           `protobuf:"bytes,1,opt,name=user" json:"user,omitempty"`
+	CustomerId string                 `protobuf:"bytes,2,opt,name=customer_id,json=customerId,proto3" json:"customer_id,omitempty"`
+	Since      *opsee_types.Timestamp `protobuf:"bytes,3,opt,name=since" json:"since,omitempty"`
+	Until      *opsee_types.Timestamp `protobuf:"bytes,4,opt,name=until" json:"until,omitempty"`
+	Limit      int32                  `protobuf:"varint,5,opt,name=limit,proto3" json:"limit,omitempty"`
+}
+
+func (m *QueryAuditRequest) Reset()         { *m = QueryAuditRequest{} }
+func (m *QueryAuditRequest) String() string { return proto.CompactTextString(m) }
+func (*QueryAuditRequest) ProtoMessage()    {}
+
+type QueryAuditResponse struct {
+	Records []*AuditRecord `protobuf:"bytes,1,rep,name=records" json:"records,omitempty"`
+}
+
+func (m *QueryAuditResponse) Reset()         { *m = QueryAuditResponse{} }
+func (m *QueryAuditResponse) String() string { return proto.CompactTextString(m) }
+func (*QueryAuditResponse) ProtoMessage()    {}
+
+// AuditRecord is a request made on a customer's behalf. Caller is the client
+// certificate identity it came from, and Code the grpc status code it was
+// answered with.
+type AuditRecord struct {
+	CustomerId   string                 `protobuf:"bytes,1,opt,name=customer_id,json=customerId,proto3" json:"customer_id,omitempty"`
+	UserId       int32                  `protobuf:"varint,2,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	Caller       string                 `protobuf:"bytes,3,opt,name=caller,proto3" json:"caller,omitempty"`
+	Method       string                 `protobuf:"bytes,4,opt,name=method,proto3" json:"method,omitempty"`
+	Region       string                 `protobuf:"bytes,5,opt,name=region,proto3" json:"region,omitempty"`
+	VpcId        string                 `protobuf:"bytes,6,opt,name=vpc_id,json=vpcId,proto3" json:"vpc_id,omitempty"`
+	RequestType  string                 `protobuf:"bytes,7,opt,name=request_type,json=requestType,proto3" json:"request_type,omitempty"`
+	Cached       bool                   `protobuf:"varint,8,opt,name=cached,proto3" json:"cached,omitempty"`
+	AWSRequestId string                 `protobuf:"bytes,9,opt,name=aws_request_id,json=awsRequestId,proto3" json:"aws_request_id,omitempty"`
+	Code         uint32                 `protobuf:"varint,10,opt,name=code,proto3" json:"code,omitempty"`
+	CreatedAt    *opsee_types.Timestamp `protobuf:"bytes,11,opt,name=created_at,json=createdAt" json:"created_at,omitempty"`
+}
+
+func (m *AuditRecord) Reset()         { *m = AuditRecord{} }
+func (m *AuditRecord) String() string { return proto.CompactTextString(m) }
+func (*AuditRecord) ProtoMessage()    {}
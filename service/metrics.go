@@ -0,0 +1,178 @@
+package service
+
+import (
+	"database/sql"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	opsee "github.com/opsee/basic/service"
+	"github.com/opsee/bezosphere/metrics"
+	"github.com/opsee/bezosphere/store"
+	log "github.com/opsee/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+)
+
+// cache results, as counted by serviceMetrics.cache
+const (
+	cacheHit      = "hit"
+	cacheStale    = "stale"
+	cacheNegative = "negative"
+	cacheMiss     = "miss"
+	cacheExpired  = "expired"
+	cacheDisabled = "disabled"
+)
+
+// spanx failure reasons, as counted by serviceMetrics.spanxFailures
+const (
+	spanxTimeout = "timeout"
+	spanxError   = "error"
+	spanxEmpty   = "empty"
+)
+
+// serviceMetrics are how requests, the cache, AWS and spanx are doing, in a
+// form Prometheus can scrape.
+type serviceMetrics struct {
+	registry *metrics.Registry
+
+	requests      *metrics.Counter
+	cache         *metrics.Counter
+	awsDuration   *metrics.Histogram
+	awsErrors     *metrics.Counter
+	spanxDuration *metrics.Histogram
+	spanxFailures *metrics.Counter
+}
+
+func newServiceMetrics(db store.Store, janitor *store.Janitor) *serviceMetrics {
+	r := metrics.NewRegistry()
+
+	m := &serviceMetrics{
+		registry: r,
+		requests: r.NewCounter(
+			"bezosphere_requests_total",
+			"Requests answered, by method, request type and grpc status.",
+			"method", "request_type", "code",
+		),
+		cache: r.NewCounter(
+			"bezosphere_cache_lookups_total",
+			"Cache lookups, by request type and result.",
+			"request_type", "result",
+		),
+		awsDuration: r.NewHistogram(
+			"bezosphere_aws_request_duration_seconds",
+			"Time spent on AWS requests, including retries and every page, by operation.",
+			metrics.DefaultBuckets,
+			"operation",
+		),
+		awsErrors: r.NewCounter(
+			"bezosphere_aws_errors_total",
+			"AWS requests that failed, by operation and AWS error code.",
+			"operation", "aws_error_code",
+		),
+		spanxDuration: r.NewHistogram(
+			"bezosphere_spanx_request_duration_seconds",
+			"Time spent getting AWS credentials from spanx.",
+			metrics.DefaultBuckets,
+		),
+		spanxFailures: r.NewCounter(
+			"bezosphere_spanx_failures_total",
+			"Failures getting AWS credentials from spanx, by reason.",
+			"reason",
+		),
+	}
+
+	if _, ok := store.DBStats(db); ok {
+		registerPoolMetrics(r, db)
+	}
+
+	if janitor != nil {
+		registerJanitorMetrics(r, janitor)
+	}
+
+	return m
+}
+
+// registerPoolMetrics exposes the stats of a postgres backed store's
+// connection pool. Our build's sql.DBStats only has the open connection
+// count.
+func registerPoolMetrics(r *metrics.Registry, db store.Store) {
+	stats := func(value func(s sql.DBStats) float64) func() float64 {
+		return func() float64 {
+			s, _ := store.DBStats(db)
+			return value(s)
+		}
+	}
+
+	r.NewGaugeFunc("bezosphere_db_open_connections", "Open connections to postgres, in use or idle.",
+		stats(func(s sql.DBStats) float64 { return float64(s.OpenConnections) }))
+}
+
+// registerJanitorMetrics exposes the janitor's counters.
+func registerJanitorMetrics(r *metrics.Registry, janitor *store.Janitor) {
+	stats := func(value func(s store.JanitorStats) int64) func() float64 {
+		return func() float64 {
+			return float64(value(janitor.Stats()))
+		}
+	}
+
+	r.NewCounterFunc("bezosphere_janitor_runs_total", "Janitor runs on this replica.",
+		stats(func(s store.JanitorStats) int64 { return s.Runs }))
+	r.NewCounterFunc("bezosphere_janitor_skipped_total", "Janitor runs skipped because another replica was running it.",
+		stats(func(s store.JanitorStats) int64 { return s.Skipped }))
+	r.NewCounterFunc("bezosphere_janitor_rows_scanned_total", "Expired resources found by the janitor.",
+		stats(func(s store.JanitorStats) int64 { return s.RowsScanned }))
+	r.NewCounterFunc("bezosphere_janitor_rows_deleted_total", "Expired resources deleted by the janitor.",
+		stats(func(s store.JanitorStats) int64 { return s.RowsDeleted }))
+}
+
+// request counts a validated request and the status it was answered with.
+func (m *serviceMetrics) request(method string, req *opsee.BezosRequest, err error) {
+	code := codes.OK
+	if err != nil {
+		code = statusOf(err).code
+	}
+
+	m.requests.Inc(method, requestType(req), code.String())
+}
+
+// lookup counts a cache lookup's result.
+func (m *serviceMetrics) lookup(req *opsee.BezosRequest, result string) {
+	m.cache.Inc(requestType(req), result)
+}
+
+// StartMetrics serves metrics over plain http at /metrics, in the Prometheus
+// text format.
+func (s *service) StartMetrics(listenAddr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s.metrics.registry)
+
+	log.Infof("starting metrics server at %s", listenAddr)
+	return http.ListenAndServe(listenAddr, mux)
+}
+
+// dispatch sends a request to AWS with dispatchRequest, timing it and
+// counting its errors.
+func (s *service) dispatch(ctx context.Context, logger *log.Entry, session *session.Session, input interface{}, output interface{}, limits *pageLimits) (string, error) {
+	operation := store.RequestType(input)
+	start := time.Now()
+
+	requestId, err := dispatchRequest(ctx, logger, session, input, output, limits)
+
+	s.metrics.awsDuration.Observe(time.Since(start).Seconds(), operation)
+	if err != nil {
+		awsCode := statusOf(err).awsCode
+		if awsCode == "" {
+			awsCode = "none"
+		}
+
+		s.metrics.awsErrors.Inc(operation, awsCode)
+	}
+
+	return requestId, err
+}
+
+func requestType(req *opsee.BezosRequest) string {
+	return reflect.TypeOf(req.Input).Elem().Name()
+}
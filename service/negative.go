@@ -0,0 +1,136 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/opsee/bezosphere/store"
+	log "github.com/opsee/logrus"
+	"google.golang.org/grpc/codes"
+)
+
+// negativeClasses are the statuses AWS errors can be cached under, by name.
+// They fail the same way every time until somebody changes something, unlike
+// throttling, timeouts and 5xxs, which are never cached.
+var negativeClasses = map[string]codes.Code{
+	"NotFound":           codes.NotFound,
+	"InvalidArgument":    codes.InvalidArgument,
+	"PermissionDenied":   codes.PermissionDenied,
+	"FailedPrecondition": codes.FailedPrecondition,
+}
+
+// DefaultNegativeTTLs is how long each class of AWS error is cached.
+func DefaultNegativeTTLs() map[codes.Code]time.Duration {
+	return map[codes.Code]time.Duration{
+		codes.NotFound:           30 * time.Second,
+		codes.InvalidArgument:    5 * time.Minute,
+		codes.PermissionDenied:   time.Minute,
+		codes.FailedPrecondition: 5 * time.Minute,
+	}
+}
+
+// ParseNegativeTTLs reads a comma separated list of status=ttl entries over
+// the defaults, where ttl is a duration or "off", e.g.
+//
+//	NotFound=1m,PermissionDenied=off
+func ParseNegativeTTLs(s string) (map[codes.Code]time.Duration, error) {
+	ttls := DefaultNegativeTTLs()
+
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid negative ttl entry: %s", entry)
+		}
+
+		code, ok := negativeClasses[parts[0]]
+		if !ok {
+			return nil, fmt.Errorf("invalid negative ttl entry: %s: errors with that status can't be cached", entry)
+		}
+
+		if parts[1] == "off" {
+			delete(ttls, code)
+			continue
+		}
+
+		ttl, err := time.ParseDuration(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid negative ttl entry: %s: %s", entry, err)
+		}
+
+		ttls[code] = ttl
+	}
+
+	return ttls, nil
+}
+
+// negativeEntry returns the cache entry for an error from AWS, or false if
+// it shouldn't be cached.
+func (s *service) negativeEntry(err error) (*store.Error, bool) {
+	awsErr, ok := err.(awserr.Error)
+	if !ok {
+		return nil, false
+	}
+
+	// the breaker deals with these, and serves the cache while it's open
+	if isCredentialFailure(err) {
+		return nil, false
+	}
+
+	statusCode := 0
+	if reqErr, ok := err.(awserr.RequestFailure); ok {
+		statusCode = reqErr.StatusCode()
+	}
+
+	if statusCode >= 500 {
+		return nil, false
+	}
+
+	ttl, ok := s.negativeTTLs[statusOf(err).code]
+	if !ok || ttl <= 0 {
+		return nil, false
+	}
+
+	return &store.Error{
+		Code:       awsErr.Code(),
+		Message:    awsErr.Message(),
+		StatusCode: statusCode,
+		TTL:        ttl,
+	}, true
+}
+
+// cacheError saves an AWS error in place of a request's output, if it can be
+// cached. It replaces whatever output was cached, since AWS will keep saying
+// the same thing. The errors that stale-if-error and the breaker fall back on
+// aren't cached, so their outputs are left alone.
+func (s *service) cacheError(logger *log.Entry, storeRequest store.Request, err error) {
+	cachedErr, ok := s.negativeEntry(err)
+	if !ok {
+		return
+	}
+
+	storeRequest.MaxAge = nil
+	storeRequest.MaxStale = 0
+	storeRequest.AWSRequestId = statusOf(err).requestId
+	storeRequest.Error = cachedErr
+
+	if err := s.db.Put(storeRequest); err != nil {
+		logger.WithError(err).Error("error caching AWS error")
+	}
+}
+
+// replayError rebuilds a cached AWS error, so it's reported just like it was
+// when AWS returned it.
+func replayError(meta *store.Metadata) error {
+	return awserr.NewRequestFailure(
+		awserr.New(meta.Error.Code, meta.Error.Message, nil),
+		meta.Error.StatusCode,
+		meta.AWSRequestId,
+	)
+}
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	opsee_aws_ec2 "github.com/opsee/basic/schema/aws/ec2"
+	"github.com/opsee/bezosphere/store"
+	log "github.com/opsee/logrus"
+	opsee_types "github.com/opsee/protobuf/opseeproto/types"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+)
+
+func vpcStoreRequest(vpcId string) store.Request {
+	return store.Request{
+		CustomerId: "customer",
+		Region:     "us-west-2",
+		VpcId:      "vpc-1",
+		Input:      &opsee_aws_ec2.DescribeVpcsInput{VpcIds: []string{vpcId}},
+		Output: &opsee_aws_ec2.DescribeVpcsOutput{Vpcs: []*opsee_aws_ec2.Vpc{
+			{VpcId: aws.String(vpcId)},
+		}},
+	}
+}
+
+func negativeService() *service {
+	return &service{
+		db:           store.NewMemory(0, nil),
+		negativeTTLs: DefaultNegativeTTLs(),
+		staleIfError: DefaultStaleIfError,
+	}
+}
+
+func TestCacheError(t *testing.T) {
+	logger := log.WithField("test", "cache error")
+
+	tests := []struct {
+		name     string
+		cached   bool
+		err      error
+		replaced bool
+	}{
+		{
+			name:     "not found with nothing cached",
+			err:      awserr.NewRequestFailure(awserr.New("InvalidVpcID.NotFound", "no such vpc", nil), 400, "request-1"),
+			replaced: true,
+		},
+		{
+			name:     "not found over a cached output",
+			cached:   true,
+			err:      awserr.NewRequestFailure(awserr.New("InvalidVpcID.NotFound", "no such vpc", nil), 400, "request-1"),
+			replaced: true,
+		},
+		{
+			name:   "throttled over a cached output",
+			cached: true,
+			err:    awserr.NewRequestFailure(awserr.New("Throttling", "slow down", nil), 400, "request-1"),
+		},
+		{
+			name: "credential failure with nothing cached",
+			err:  awserr.NewRequestFailure(awserr.New("AccessDenied", "no", nil), 403, "request-1"),
+		},
+		{
+			name:   "credential failure over a cached output",
+			cached: true,
+			err:    awserr.NewRequestFailure(awserr.New("AccessDenied", "no", nil), 403, "request-1"),
+		},
+		{
+			name: "server error",
+			err:  awserr.NewRequestFailure(awserr.New("InternalError", "oops", nil), 500, "request-1"),
+		},
+		{
+			name: "not an AWS error",
+			err:  ErrRateLimited,
+		},
+	}
+
+	for _, test := range tests {
+		s := negativeService()
+
+		if test.cached {
+			if err := s.db.Put(vpcStoreRequest("vpc-1")); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		s.cacheError(logger, vpcStoreRequest("vpc-1"), test.err)
+
+		get := vpcStoreRequest("vpc-1")
+		get.Output = &opsee_aws_ec2.DescribeVpcsOutput{}
+
+		meta, err := s.db.Get(get)
+		switch {
+		case test.replaced:
+			if err != nil || meta.Error == nil {
+				t.Errorf("%s: expected a cached error, got %#v, %v", test.name, meta, err)
+			}
+
+		case test.cached:
+			if err != nil || meta.Error != nil {
+				t.Errorf("%s: expected the output to survive, got %#v, %v", test.name, meta, err)
+			}
+
+			// the breaker and stale-if-error still have something to serve
+			if s.staleFallback(logger, vpcStoreRequest("vpc-1")) == nil {
+				t.Errorf("%s: expected a stale fallback", test.name)
+			}
+
+		default:
+			if err == nil {
+				t.Errorf("%s: expected nothing cached, got %#v", test.name, meta)
+			}
+		}
+	}
+}
+
+func TestNotFoundAfterCached(t *testing.T) {
+	fake := newFakeAWS(vpcs("vpc-1"))
+	defer fake.Close()
+
+	s := fakeService(t, fake)
+	logger := log.WithField("test", "not found after cached")
+
+	if _, err := s.resolve(context.Background(), logger, fake.session, vpcsRequest(), options{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the vpc is deleted, and a caller wants to know for sure
+	fake.answer(awsError(http.StatusBadRequest, "InvalidVpcID.NotFound"))
+
+	// ages are compared to the millisecond
+	time.Sleep(5 * time.Millisecond)
+
+	fresh := vpcsRequest()
+	fresh.MaxAge = &opsee_types.Timestamp{}
+	fresh.MaxAge.Scan(time.Now().UTC())
+
+	if _, err := s.resolve(context.Background(), logger, fake.session, fresh, options{}); statusOf(err).code != codes.NotFound {
+		t.Fatalf("expected not found, got %v", err)
+	}
+
+	calls := fake.requests()
+
+	for i := 0; i < 3; i++ {
+		_, err := s.resolve(context.Background(), logger, fake.session, vpcsRequest(), options{})
+		if statusOf(err).code != codes.NotFound {
+			t.Errorf("expected the cached not found, got %v", err)
+		}
+	}
+
+	if fake.requests() != calls {
+		t.Errorf("expected not found to be answered from the cache, AWS got %d more calls", fake.requests()-calls)
+	}
+}
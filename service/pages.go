@@ -0,0 +1,133 @@
+package service
+
+import (
+	"reflect"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	log "github.com/opsee/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	AllPagesHeader = "bezosphere-all-pages"
+
+	DefaultMaxPages     = 20
+	DefaultMaxPageItems = 5000
+)
+
+// options are per call settings sent by clients as grpc metadata, since
+// BezosRequest has no room for them.
+type options struct {
+	allPages   bool
+	regionWide bool
+}
+
+func requestOptions(ctx context.Context) options {
+	var opts options
+
+	md, ok := metadata.FromContext(ctx)
+	if !ok {
+		return opts
+	}
+
+	for _, v := range md[AllPagesHeader] {
+		if v == "true" {
+			opts.allPages = true
+		}
+	}
+
+	for _, v := range md[RegionWideHeader] {
+		if v == "true" {
+			opts.regionWide = true
+		}
+	}
+
+	return opts
+}
+
+// pageLimits bounds how much of a paginated operation is walked when a request
+// asks for all pages.
+type pageLimits struct {
+	maxPages int
+	maxItems int
+}
+
+// sendAllPages walks every page of a request, appending each page's lists onto
+// the first page's output. If a limit is hit the walk stops early and the
+// output keeps the token of the last page merged, so callers can tell it's
+// partial and carry on from there. A page that would take the output over the
+// item limit isn't merged at all, so the limit only gives way to a first page
+// that's bigger than it.
+func sendAllPages(logger *log.Entry, awsRequest *request.Request, limits *pageLimits) error {
+	var (
+		merged = reflect.ValueOf(awsRequest.Data).Elem()
+		pages  int
+	)
+
+	partial := func() bool {
+		logger.WithFields(log.Fields{
+			"pages": pages,
+			"items": countItems(merged),
+		}).Warn("pagination limit reached, returning partial results")
+		return false
+	}
+
+	err := awsRequest.EachPage(func(page interface{}, lastPage bool) bool {
+		if pages > 0 {
+			next := reflect.ValueOf(page).Elem()
+			if countItems(merged)+countItems(next) > limits.maxItems {
+				return partial()
+			}
+
+			mergePage(merged, next)
+		}
+		pages++
+
+		if lastPage {
+			return false
+		}
+
+		if pages >= limits.maxPages || countItems(merged) >= limits.maxItems {
+			return partial()
+		}
+
+		return true
+	})
+
+	if err != nil {
+		return err
+	}
+
+	logger.WithField("pages", pages).Debug("fetched all pages")
+	return nil
+}
+
+// mergePage appends src's slices onto dst's and takes everything else, like
+// pagination tokens, from src.
+func mergePage(dst, src reflect.Value) {
+	for i := 0; i < dst.NumField(); i++ {
+		field := dst.Field(i)
+		if !field.CanSet() {
+			continue
+		}
+
+		if field.Kind() == reflect.Slice {
+			field.Set(reflect.AppendSlice(field, src.Field(i)))
+		} else {
+			field.Set(src.Field(i))
+		}
+	}
+}
+
+func countItems(v reflect.Value) int {
+	var items int
+
+	for i := 0; i < v.NumField(); i++ {
+		if v.Field(i).Kind() == reflect.Slice {
+			items += v.Field(i).Len()
+		}
+	}
+
+	return items
+}
@@ -0,0 +1,193 @@
+package service
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	log "github.com/opsee/logrus"
+)
+
+func TestMergePage(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		dst, src interface{}
+		expected interface{}
+	}{
+		{
+			name:     "appends lists and takes the token",
+			dst:      &ec2.DescribeTagsOutput{Tags: tagList("a"), NextToken: aws.String("1")},
+			src:      &ec2.DescribeTagsOutput{Tags: tagList("b", "c"), NextToken: aws.String("2")},
+			expected: &ec2.DescribeTagsOutput{Tags: tagList("a", "b", "c"), NextToken: aws.String("2")},
+		},
+		{
+			name:     "last page clears the token",
+			dst:      &ec2.DescribeTagsOutput{Tags: tagList("a"), NextToken: aws.String("1")},
+			src:      &ec2.DescribeTagsOutput{Tags: tagList("b")},
+			expected: &ec2.DescribeTagsOutput{Tags: tagList("a", "b")},
+		},
+		{
+			name:     "empty page",
+			dst:      &ec2.DescribeTagsOutput{Tags: tagList("a"), NextToken: aws.String("1")},
+			src:      &ec2.DescribeTagsOutput{NextToken: aws.String("2")},
+			expected: &ec2.DescribeTagsOutput{Tags: tagList("a"), NextToken: aws.String("2")},
+		},
+		{
+			name:     "not paginated",
+			dst:      &ec2.DescribeVpcsOutput{Vpcs: []*ec2.Vpc{{VpcId: aws.String("vpc-1")}}},
+			src:      &ec2.DescribeVpcsOutput{Vpcs: []*ec2.Vpc{{VpcId: aws.String("vpc-2")}}},
+			expected: &ec2.DescribeVpcsOutput{Vpcs: []*ec2.Vpc{{VpcId: aws.String("vpc-1")}, {VpcId: aws.String("vpc-2")}}},
+		},
+	} {
+		mergePage(reflect.ValueOf(test.dst).Elem(), reflect.ValueOf(test.src).Elem())
+
+		if !reflect.DeepEqual(test.dst, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, test.dst)
+		}
+	}
+}
+
+func TestSendAllPages(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		pages     [][]string
+		limits    pageLimits
+		keys      []string
+		nextToken *string
+		requests  int
+	}{
+		{
+			name:     "single page",
+			pages:    [][]string{{"a", "b"}},
+			limits:   pageLimits{maxPages: 10, maxItems: 100},
+			keys:     []string{"a", "b"},
+			requests: 1,
+		},
+		{
+			name:     "merges every page",
+			pages:    [][]string{{"a", "b"}, {"c"}, {"d", "e"}},
+			limits:   pageLimits{maxPages: 10, maxItems: 100},
+			keys:     []string{"a", "b", "c", "d", "e"},
+			requests: 3,
+		},
+		{
+			name:      "page limit",
+			pages:     [][]string{{"a", "b"}, {"c"}, {"d", "e"}},
+			limits:    pageLimits{maxPages: 2, maxItems: 100},
+			keys:      []string{"a", "b", "c"},
+			nextToken: aws.String("2"),
+			requests:  2,
+		},
+		{
+			name:      "item limit reached",
+			pages:     [][]string{{"a", "b"}, {"c"}, {"d", "e"}},
+			limits:    pageLimits{maxPages: 10, maxItems: 3},
+			keys:      []string{"a", "b", "c"},
+			nextToken: aws.String("2"),
+			requests:  2,
+		},
+		{
+			name:      "page over the item limit isn't merged",
+			pages:     [][]string{{"a", "b"}, {"c"}, {"d", "e"}},
+			limits:    pageLimits{maxPages: 10, maxItems: 4},
+			keys:      []string{"a", "b", "c"},
+			nextToken: aws.String("2"),
+			requests:  3,
+		},
+		{
+			name:      "last page over the item limit isn't merged",
+			pages:     [][]string{{"a", "b"}, {"c", "d"}},
+			limits:    pageLimits{maxPages: 10, maxItems: 3},
+			keys:      []string{"a", "b"},
+			nextToken: aws.String("1"),
+			requests:  2,
+		},
+		{
+			name:      "first page over the item limit",
+			pages:     [][]string{{"a", "b"}, {"c"}},
+			limits:    pageLimits{maxPages: 10, maxItems: 1},
+			keys:      []string{"a", "b"},
+			nextToken: aws.String("1"),
+			requests:  1,
+		},
+	} {
+		fake := newFakeAWS(tagPages(test.pages))
+
+		awsRequest, output := ec2.New(fake.session()).DescribeTagsRequest(&ec2.DescribeTagsInput{})
+		err := sendAllPages(log.WithField("test", test.name), awsRequest, &test.limits)
+		fake.Close()
+
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		var keys []string
+		for _, tag := range output.Tags {
+			keys = append(keys, aws.StringValue(tag.Key))
+		}
+
+		if !reflect.DeepEqual(keys, test.keys) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.keys, keys)
+		}
+
+		if !reflect.DeepEqual(output.NextToken, test.nextToken) {
+			t.Errorf("%s: expected next token %q, got %q", test.name, aws.StringValue(test.nextToken), aws.StringValue(output.NextToken))
+		}
+
+		if n := fake.requests(); n != test.requests {
+			t.Errorf("%s: expected %d requests, got %d", test.name, test.requests, n)
+		}
+	}
+}
+
+func TestSendAllPagesNotPaginated(t *testing.T) {
+	fake := newFakeAWS(vpcs("vpc-1", "vpc-2"))
+	defer fake.Close()
+
+	awsRequest, output := ec2.New(fake.session()).DescribeVpcsRequest(&ec2.DescribeVpcsInput{})
+	err := sendAllPages(log.WithField("test", "not paginated"), awsRequest, &pageLimits{maxPages: 10, maxItems: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(output.Vpcs) != 2 {
+		t.Errorf("expected the one page to be kept whole, got %d vpcs", len(output.Vpcs))
+	}
+
+	if n := fake.requests(); n != 1 {
+		t.Errorf("expected 1 request, got %d", n)
+	}
+}
+
+func tagList(keys ...string) []*ec2.TagDescription {
+	tags := make([]*ec2.TagDescription, len(keys))
+	for i, key := range keys {
+		tags[i] = &ec2.TagDescription{Key: aws.String(key)}
+	}
+	return tags
+}
+
+// tagPages answers DescribeTags with pages, using each page's index as the
+// token for it.
+func tagPages(pages [][]string) func(*http.Request) (int, string) {
+	return func(r *http.Request) (int, string) {
+		page, _ := strconv.Atoi(r.Form.Get("NextToken"))
+
+		items := make([]string, len(pages[page]))
+		for i, key := range pages[page] {
+			items[i] = "<item><key>" + key + "</key></item>"
+		}
+
+		var nextToken string
+		if page+1 < len(pages) {
+			nextToken = "<nextToken>" + strconv.Itoa(page+1) + "</nextToken>"
+		}
+
+		return http.StatusOK, "<DescribeTagsResponse><tagSet>" + strings.Join(items, "") + "</tagSet>" + nextToken + "</DescribeTagsResponse>"
+	}
+}
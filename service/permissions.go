@@ -0,0 +1,124 @@
+package service
+
+import (
+	"errors"
+	"reflect"
+
+	"github.com/opsee/basic/schema"
+	opsee_aws_autoscaling "github.com/opsee/basic/schema/aws/autoscaling"
+	opsee_aws_cloudwatch "github.com/opsee/basic/schema/aws/cloudwatch"
+	opsee_aws_ec2 "github.com/opsee/basic/schema/aws/ec2"
+	opsee_aws_ecs "github.com/opsee/basic/schema/aws/ecs"
+	opsee_aws_elb "github.com/opsee/basic/schema/aws/elb"
+	opsee_aws_rds "github.com/opsee/basic/schema/aws/rds"
+)
+
+// User flags, see schema.UserFlags.
+const (
+	PermEdit  = "edit"
+	PermAdmin = "admin"
+)
+
+var (
+	ErrPermissionDenied = errors.New("user does not have permission to make this request.")
+)
+
+// permission is a named set of things a user can do. It's granted to users
+// with any of its flags, or to every valid user if it has none. Opsee admins
+// have every permission.
+type permission struct {
+	name  string
+	flags []string
+}
+
+// Any valid user can read the customer's instances and what's around them,
+// while metrics, databases and ECS, whose task definitions hold environment
+// variables, need edit or admin.
+var (
+	permAutoscalingRead = permission{name: "aws:autoscaling:read"}
+	permEC2Read         = permission{name: "aws:ec2:read"}
+	permELBRead         = permission{name: "aws:elb:read"}
+	permCloudWatchRead  = permission{name: "aws:cloudwatch:read", flags: []string{PermEdit, PermAdmin}}
+	permECSRead         = permission{name: "aws:ecs:read", flags: []string{PermEdit, PermAdmin}}
+	permRDSRead         = permission{name: "aws:rds:read", flags: []string{PermEdit, PermAdmin}}
+
+	// permRegionWide lets a user opt out of vpc scoping, see scopeInput.
+	permRegionWide = permission{name: "region-wide", flags: []string{PermAdmin}}
+
+	// permAuditRead lets a user query their customer's audit log.
+	permAuditRead = permission{name: "audit:read", flags: []string{PermAdmin}}
+)
+
+// requiredPermissions is the permission a user needs to make each type of
+// request. Input types that aren't here can't be requested by anyone but
+// Opsee admins.
+var requiredPermissions = map[reflect.Type]permission{
+	reflect.TypeOf(&opsee_aws_autoscaling.DescribeAutoScalingGroupsInput{}): permAutoscalingRead,
+
+	reflect.TypeOf(&opsee_aws_cloudwatch.ListMetricsInput{}):             permCloudWatchRead,
+	reflect.TypeOf(&opsee_aws_cloudwatch.GetMetricStatisticsInput{}):     permCloudWatchRead,
+	reflect.TypeOf(&opsee_aws_cloudwatch.DescribeAlarmsInput{}):          permCloudWatchRead,
+	reflect.TypeOf(&opsee_aws_cloudwatch.DescribeAlarmsForMetricInput{}): permCloudWatchRead,
+
+	reflect.TypeOf(&opsee_aws_ec2.DescribeInstancesInput{}):      permEC2Read,
+	reflect.TypeOf(&opsee_aws_ec2.DescribeSecurityGroupsInput{}): permEC2Read,
+	reflect.TypeOf(&opsee_aws_ec2.DescribeSubnetsInput{}):        permEC2Read,
+	reflect.TypeOf(&opsee_aws_ec2.DescribeVpcsInput{}):           permEC2Read,
+	reflect.TypeOf(&opsee_aws_ec2.DescribeRouteTablesInput{}):    permEC2Read,
+
+	reflect.TypeOf(&opsee_aws_ecs.ListClustersInput{}):               permECSRead,
+	reflect.TypeOf(&opsee_aws_ecs.ListServicesInput{}):               permECSRead,
+	reflect.TypeOf(&opsee_aws_ecs.DescribeServicesInput{}):           permECSRead,
+	reflect.TypeOf(&opsee_aws_ecs.ListTasksInput{}):                  permECSRead,
+	reflect.TypeOf(&opsee_aws_ecs.DescribeTasksInput{}):              permECSRead,
+	reflect.TypeOf(&opsee_aws_ecs.ListContainerInstancesInput{}):     permECSRead,
+	reflect.TypeOf(&opsee_aws_ecs.DescribeContainerInstancesInput{}): permECSRead,
+	reflect.TypeOf(&opsee_aws_ecs.DescribeTaskDefinitionInput{}):     permECSRead,
+
+	reflect.TypeOf(&opsee_aws_elb.DescribeLoadBalancersInput{}): permELBRead,
+
+	reflect.TypeOf(&opsee_aws_rds.DescribeDBInstancesInput{}): permRDSRead,
+}
+
+// authorize checks a user is allowed to make a request with input.
+func authorize(user *schema.User, input interface{}) error {
+	perm, ok := requiredPermissions[reflect.TypeOf(input)]
+	if !ok {
+		if user.Validate() == nil && user.IsOpseeAdmin() {
+			return nil
+		}
+
+		return ErrPermissionDenied
+	}
+
+	if !hasPermission(user, perm) {
+		return ErrPermissionDenied
+	}
+
+	return nil
+}
+
+// hasPermission checks a user has been granted perm. The user must be valid,
+// which unlike schema.User.HasPermissions accepts an active user with no
+// status.
+func hasPermission(user *schema.User, perm permission) bool {
+	if user.Validate() != nil {
+		return false
+	}
+
+	if user.IsOpseeAdmin() || len(perm.flags) == 0 {
+		return true
+	}
+
+	if user.Perms == nil {
+		return false
+	}
+
+	for _, flag := range perm.flags {
+		if user.Perms.TestFlag(flag) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,141 @@
+package service
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/opsee/basic/schema"
+	opsee "github.com/opsee/basic/service"
+	"github.com/opsee/bezosphere/store"
+)
+
+func testUser(flags ...string) *schema.User {
+	user := &schema.User{
+		Id:         1,
+		CustomerId: "customer",
+		Email:      "user@example.com",
+		Active:     true,
+		Status:     "active",
+		Perms:      &schema.UserFlags{},
+	}
+	user.Perms.SetFlags(flags...)
+	return user
+}
+
+func opseeAdmin() *schema.User {
+	user := testUser()
+	user.Admin = true
+	return user
+}
+
+// allInputs returns an input for every type a BezosRequest can carry.
+func allInputs(t *testing.T) []interface{} {
+	_, _, _, wrappers := (*opsee.BezosRequest)(nil).XXX_OneofFuncs()
+
+	inputs := make([]interface{}, 0, len(wrappers))
+	for _, wrapper := range wrappers {
+		w := reflect.New(reflect.TypeOf(wrapper).Elem())
+		field := w.Elem().Field(0)
+		field.Set(reflect.New(field.Type().Elem()))
+
+		input, _, err := inputOutput(w.Interface())
+		if err != nil {
+			t.Fatalf("%T: %v", wrapper, err)
+		}
+
+		inputs = append(inputs, input)
+	}
+
+	return inputs
+}
+
+func TestAuthorize(t *testing.T) {
+	// services any valid user can read, the rest need edit or admin
+	open := map[string]bool{"autoscaling": true, "ec2": true, "elb": true}
+
+	noStatus := testUser()
+	noStatus.Status = ""
+
+	inactive := opseeAdmin()
+	inactive.Status = "inactive"
+
+	for _, input := range allInputs(t) {
+		name := reflect.TypeOf(input).String()
+		service := strings.SplitN(store.RequestType(input), ".", 2)[0]
+
+		perm, ok := requiredPermissions[reflect.TypeOf(input)]
+		if !ok {
+			t.Errorf("%s: no required permission", name)
+			continue
+		}
+
+		if perm.name != "aws:"+service+":read" {
+			t.Errorf("%s: expected aws:%s:read, got %s", name, service, perm.name)
+		}
+
+		tests := []struct {
+			user    string
+			u       *schema.User
+			allowed bool
+		}{
+			{"a user without flags", testUser(), open[service]},
+			{"an active user without a status", noStatus, open[service]},
+			{"an editor", testUser(PermEdit), true},
+			{"a team admin", testUser(PermAdmin), true},
+			{"an opsee admin", opseeAdmin(), true},
+			{"an inactive opsee admin", inactive, false},
+		}
+
+		for _, test := range tests {
+			err := authorize(test.u, input)
+			if test.allowed && err != nil {
+				t.Errorf("%s: expected %s to be allowed, got %v", name, test.user, err)
+			} else if !test.allowed && err != ErrPermissionDenied {
+				t.Errorf("%s: expected %s to be denied, got %v", name, test.user, err)
+			}
+		}
+	}
+}
+
+func TestAuthorizeUnknownInput(t *testing.T) {
+	input := &opsee.BezosRequest{}
+
+	if err := authorize(testUser(PermAdmin, PermEdit), input); err != ErrPermissionDenied {
+		t.Errorf("expected an unknown input to be denied, got %v", err)
+	}
+
+	if err := authorize(opseeAdmin(), input); err != nil {
+		t.Errorf("expected an opsee admin to be allowed any input, got %v", err)
+	}
+}
+
+func TestHasPermission(t *testing.T) {
+	noPerms := testUser()
+	noPerms.Perms = nil
+
+	tests := []struct {
+		name    string
+		user    *schema.User
+		perm    permission
+		allowed bool
+	}{
+		{"no flags, ec2", testUser(), permEC2Read, true},
+		{"no flags, rds", testUser(), permRDSRead, false},
+		{"no perms, ecs", noPerms, permECSRead, false},
+		{"no flags, region wide", testUser(), permRegionWide, false},
+		{"editor, cloudwatch", testUser(PermEdit), permCloudWatchRead, true},
+		{"editor, audit", testUser(PermEdit), permAuditRead, false},
+		{"team admin, ecs", testUser(PermAdmin), permECSRead, true},
+		{"team admin, region wide", testUser(PermAdmin), permRegionWide, true},
+		{"team admin, audit", testUser(PermAdmin), permAuditRead, true},
+		{"opsee admin, region wide", opseeAdmin(), permRegionWide, true},
+		{"invalid user, ec2", &schema.User{Id: 1, CustomerId: "customer", Active: true}, permEC2Read, false},
+	}
+
+	for _, test := range tests {
+		if allowed := hasPermission(test.user, test.perm); allowed != test.allowed {
+			t.Errorf("%s: expected %v, got %v", test.name, test.allowed, allowed)
+		}
+	}
+}
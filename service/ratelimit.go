@@ -0,0 +1,167 @@
+package service
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	DefaultRateLimit = 10.0
+	DefaultRateBurst = 20
+
+	DefaultMaxRetries      = 3
+	DefaultThrottleBackoff = 500 * time.Millisecond
+	DefaultMaxBackoff      = 10 * time.Second
+)
+
+var (
+	ErrRateLimited = errors.New("too many AWS requests for this customer, try again later.")
+)
+
+// rateLimiter keeps a token bucket for every customer, region and AWS
+// service, so one customer's traffic can't use up their own API quota. Every
+// call to AWS takes a token, so a request that walks many pages or retries
+// pays for each of them. Full buckets are dropped, they're the same as a new
+// one.
+type rateLimiter struct {
+	sync.Mutex
+
+	rate          float64
+	burst         float64
+	buckets       map[bucketKey]*bucket
+	lastEvictedAt time.Time
+}
+
+type bucketKey struct {
+	customerId string
+	region     string
+	service    string
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:          rate,
+		burst:         float64(burst),
+		buckets:       make(map[bucketKey]*bucket),
+		lastEvictedAt: time.Now(),
+	}
+}
+
+// limit charges every AWS call made with handlers to a customer's bucket for
+// the call's service. That's each attempt at each page of a request, since
+// they're all signed separately. Calls over the limit fail with
+// ErrRateLimited before they're signed, and aren't retried. Signing stops at
+// the first error, otherwise the signer clients add after us would clear it
+// and send the call anyway.
+func (l *rateLimiter) limit(customerId, region string, handlers *request.Handlers) {
+	handlers.Sign.AfterEachFn = request.HandlerListStopOnError
+	handlers.Sign.PushFront(func(r *request.Request) {
+		if !l.take(customerId, region, r.ClientInfo.ServiceName) {
+			r.Error = ErrRateLimited
+		}
+	})
+}
+
+// take takes a token for a call to an AWS service, returning false if there
+// aren't any left.
+func (l *rateLimiter) take(customerId, region, service string) bool {
+	l.Lock()
+	defer l.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastEvictedAt) > l.fillTime() {
+		l.evict(now)
+	}
+
+	key := bucketKey{
+		customerId: customerId,
+		region:     region,
+		service:    service,
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	l.refill(b, now)
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
+
+func (l *rateLimiter) refill(b *bucket, now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+}
+
+// fillTime is how long an empty bucket takes to fill.
+func (l *rateLimiter) fillTime() time.Duration {
+	return time.Duration(l.burst / l.rate * float64(time.Second))
+}
+
+// evict drops full buckets. It must be called with the limiter locked.
+func (l *rateLimiter) evict(now time.Time) {
+	for key, b := range l.buckets {
+		l.refill(b, now)
+		if b.tokens >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+
+	l.lastEvictedAt = now
+}
+
+// throttleRetryer retries like the SDK's default retryer, except that
+// throttled requests back off for a random time up to an exponentially
+// growing limit, so requests throttled together don't retry together.
+type throttleRetryer struct {
+	client.DefaultRetryer
+}
+
+func newRetryer() request.Retryer {
+	return throttleRetryer{client.DefaultRetryer{NumMaxRetries: DefaultMaxRetries}}
+}
+
+func (r throttleRetryer) RetryRules(req *request.Request) time.Duration {
+	if !isThrottle(req.Error) {
+		return r.DefaultRetryer.RetryRules(req)
+	}
+
+	backoff := DefaultThrottleBackoff << uint(req.RetryCount)
+	if backoff <= 0 || backoff > DefaultMaxBackoff {
+		backoff = DefaultMaxBackoff
+	}
+
+	return time.Duration(rand.Int63n(int64(backoff)))
+}
+
+func isThrottle(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+
+	code, ok := awsErrorCodes[awsErr.Code()]
+	return ok && code == codes.ResourceExhausted
+}
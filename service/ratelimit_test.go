@@ -0,0 +1,107 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestRateLimiterTake(t *testing.T) {
+	l := newRateLimiter(1, 2)
+
+	for i := 0; i < 2; i++ {
+		if !l.take("customer", "us-west-2", "ec2") {
+			t.Fatalf("expected call %d to be allowed within the burst", i+1)
+		}
+	}
+
+	if l.take("customer", "us-west-2", "ec2") {
+		t.Error("expected the bucket to be empty after the burst")
+	}
+
+	if !l.take("customer", "us-west-2", "elb") || !l.take("customer", "us-east-1", "ec2") || !l.take("other", "us-west-2", "ec2") {
+		t.Error("expected other services, regions and customers to have their own buckets")
+	}
+}
+
+func TestRateLimiterRefill(t *testing.T) {
+	l := newRateLimiter(1, 2)
+
+	for l.take("customer", "us-west-2", "ec2") {
+	}
+
+	// pretend a second and a half went by
+	b := l.buckets[bucketKey{customerId: "customer", region: "us-west-2", service: "ec2"}]
+	b.last = b.last.Add(-1500 * time.Millisecond)
+
+	if !l.take("customer", "us-west-2", "ec2") {
+		t.Fatal("expected a token to have been refilled")
+	}
+
+	if l.take("customer", "us-west-2", "ec2") {
+		t.Error("expected only one whole token to have been refilled")
+	}
+
+	// a long time later, the bucket is full but no fuller
+	b.last = b.last.Add(-time.Hour)
+	l.refill(b, time.Now())
+	if b.tokens != l.burst {
+		t.Errorf("expected the bucket to be capped at %v tokens, got %v", l.burst, b.tokens)
+	}
+}
+
+func TestRateLimiterEvict(t *testing.T) {
+	l := newRateLimiter(1, 2)
+
+	l.take("full", "us-west-2", "ec2")
+	l.take("partial", "us-west-2", "ec2")
+	l.take("partial", "us-west-2", "ec2")
+
+	full := l.buckets[bucketKey{customerId: "full", region: "us-west-2", service: "ec2"}]
+	full.last = full.last.Add(-time.Hour)
+
+	l.evict(time.Now())
+
+	if _, ok := l.buckets[bucketKey{customerId: "full", region: "us-west-2", service: "ec2"}]; ok {
+		t.Error("expected the full bucket to be dropped")
+	}
+
+	if _, ok := l.buckets[bucketKey{customerId: "partial", region: "us-west-2", service: "ec2"}]; !ok {
+		t.Error("expected the partly used bucket to be kept")
+	}
+
+	// evicting must not hand out tokens
+	if l.take("partial", "us-west-2", "ec2") {
+		t.Error("expected the partly used bucket to keep its count")
+	}
+}
+
+func TestRateLimiterLimit(t *testing.T) {
+	var sent int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&sent, 1)
+	}))
+	defer server.Close()
+
+	// an empty bucket that never refills
+	limiter := newRateLimiter(0.0001, 0)
+	pool := newSessionPool(nil, time.Second, time.Minute, time.Minute, limiter, newServiceMetrics(nil, nil))
+
+	sess := pool.get(testUser(), "us-west-2")
+	client := ec2.New(sess, &aws.Config{Endpoint: aws.String(server.URL)})
+
+	// credentials are never fetched either, the call is turned away first
+	req, _ := client.DescribeInstancesRequest(&ec2.DescribeInstancesInput{})
+	if err := req.Send(); err != ErrRateLimited {
+		t.Errorf("expected %v, got %v", ErrRateLimited, err)
+	}
+
+	if n := atomic.LoadInt32(&sent); n != 0 {
+		t.Errorf("expected nothing to be sent to AWS, got %d requests", n)
+	}
+}
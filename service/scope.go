@@ -0,0 +1,106 @@
+package service
+
+import (
+	"errors"
+
+	"github.com/gogo/protobuf/proto"
+	opsee_aws_ec2 "github.com/opsee/basic/schema/aws/ec2"
+	opsee_aws_elb "github.com/opsee/basic/schema/aws/elb"
+	opsee_aws_rds "github.com/opsee/basic/schema/aws/rds"
+	opsee "github.com/opsee/basic/service"
+)
+
+const (
+	RegionWideHeader = "bezosphere-region-wide"
+
+	// regionWideVpcId is the vpc region wide resources are cached under, so
+	// they never share a key with the scoped version of the same request.
+	regionWideVpcId = "*"
+
+	vpcIdFilter = "vpc-id"
+)
+
+var (
+	ErrRegionWideDenied = errors.New("user is not allowed to make region wide requests.")
+)
+
+// scopeInput restricts a request's input to the request's vpc, returning the
+// input to send and the vpc to cache it under. Operations that take a vpc-id
+// filter get a copy of the input with one, replacing any the caller sent.
+// Callers can opt out with the region wide header, if they're allowed to.
+func scopeInput(req *opsee.BezosRequest, input interface{}, opts options) (interface{}, string, error) {
+	if opts.regionWide {
+		if !hasPermission(req.User, permRegionWide) {
+			return nil, "", ErrRegionWideDenied
+		}
+
+		return input, regionWideVpcId, nil
+	}
+
+	switch t := input.(type) {
+	case *opsee_aws_ec2.DescribeInstancesInput:
+		scoped := proto.Clone(t).(*opsee_aws_ec2.DescribeInstancesInput)
+		scoped.Filters = withVpcFilter(scoped.Filters, req.VpcId)
+		return scoped, req.VpcId, nil
+
+	case *opsee_aws_ec2.DescribeSubnetsInput:
+		scoped := proto.Clone(t).(*opsee_aws_ec2.DescribeSubnetsInput)
+		scoped.Filters = withVpcFilter(scoped.Filters, req.VpcId)
+		return scoped, req.VpcId, nil
+
+	case *opsee_aws_ec2.DescribeSecurityGroupsInput:
+		scoped := proto.Clone(t).(*opsee_aws_ec2.DescribeSecurityGroupsInput)
+		scoped.Filters = withVpcFilter(scoped.Filters, req.VpcId)
+		return scoped, req.VpcId, nil
+
+	case *opsee_aws_ec2.DescribeRouteTablesInput:
+		scoped := proto.Clone(t).(*opsee_aws_ec2.DescribeRouteTablesInput)
+		scoped.Filters = withVpcFilter(scoped.Filters, req.VpcId)
+		return scoped, req.VpcId, nil
+	}
+
+	return input, req.VpcId, nil
+}
+
+func withVpcFilter(filters []*opsee_aws_ec2.Filter, vpcId string) []*opsee_aws_ec2.Filter {
+	scoped := make([]*opsee_aws_ec2.Filter, 0, len(filters)+1)
+
+	for _, filter := range filters {
+		if filter.GetName() != vpcIdFilter {
+			scoped = append(scoped, filter)
+		}
+	}
+
+	return append(scoped, &opsee_aws_ec2.Filter{
+		Name:   proto.String(vpcIdFilter),
+		Values: []string{vpcId},
+	})
+}
+
+// scopeOutput drops everything outside of vpcId from the output of operations
+// that can't filter by vpc themselves. Region wide outputs are left alone.
+func scopeOutput(output interface{}, vpcId string) {
+	if vpcId == regionWideVpcId {
+		return
+	}
+
+	switch t := output.(type) {
+	case *opsee_aws_elb.DescribeLoadBalancersOutput:
+		var scoped []*opsee_aws_elb.LoadBalancerDescription
+		for _, lb := range t.LoadBalancerDescriptions {
+			if lb.GetVPCId() == vpcId {
+				scoped = append(scoped, lb)
+			}
+		}
+		t.LoadBalancerDescriptions = scoped
+
+	case *opsee_aws_rds.DescribeDBInstancesOutput:
+		var scoped []*opsee_aws_rds.DBInstance
+		for _, db := range t.DBInstances {
+			if db.GetDBSubnetGroup().GetVpcId() == vpcId {
+				scoped = append(scoped, db)
+			}
+		}
+		t.DBInstances = scoped
+	}
+}
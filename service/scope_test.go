@@ -0,0 +1,271 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	opsee_aws_ec2 "github.com/opsee/basic/schema/aws/ec2"
+	opsee_aws_elb "github.com/opsee/basic/schema/aws/elb"
+	opsee_aws_rds "github.com/opsee/basic/schema/aws/rds"
+	opsee "github.com/opsee/basic/service"
+	"github.com/opsee/bezosphere/store"
+)
+
+func filterValues(filters []*opsee_aws_ec2.Filter) map[string][]string {
+	values := make(map[string][]string)
+	for _, filter := range filters {
+		values[filter.GetName()] = append(values[filter.GetName()], filter.Values...)
+	}
+	return values
+}
+
+func TestScopeInput(t *testing.T) {
+	stateFilter := &opsee_aws_ec2.Filter{Name: aws.String("instance-state-name"), Values: []string{"running"}}
+
+	tests := []struct {
+		name    string
+		input   interface{}
+		filters func(interface{}) []*opsee_aws_ec2.Filter
+	}{
+		{
+			name:  "instances without filters",
+			input: &opsee_aws_ec2.DescribeInstancesInput{},
+			filters: func(input interface{}) []*opsee_aws_ec2.Filter {
+				return input.(*opsee_aws_ec2.DescribeInstancesInput).Filters
+			},
+		},
+		{
+			name: "instances in another vpc",
+			input: &opsee_aws_ec2.DescribeInstancesInput{Filters: []*opsee_aws_ec2.Filter{
+				{Name: aws.String(vpcIdFilter), Values: []string{"vpc-other"}},
+				stateFilter,
+			}},
+			filters: func(input interface{}) []*opsee_aws_ec2.Filter {
+				return input.(*opsee_aws_ec2.DescribeInstancesInput).Filters
+			},
+		},
+		{
+			name: "subnets in several vpcs",
+			input: &opsee_aws_ec2.DescribeSubnetsInput{Filters: []*opsee_aws_ec2.Filter{
+				{Name: aws.String(vpcIdFilter), Values: []string{"vpc-1", "vpc-other"}},
+			}},
+			filters: func(input interface{}) []*opsee_aws_ec2.Filter {
+				return input.(*opsee_aws_ec2.DescribeSubnetsInput).Filters
+			},
+		},
+		{
+			name: "security groups in another vpc",
+			input: &opsee_aws_ec2.DescribeSecurityGroupsInput{Filters: []*opsee_aws_ec2.Filter{
+				{Name: aws.String(vpcIdFilter), Values: []string{"vpc-other"}},
+			}},
+			filters: func(input interface{}) []*opsee_aws_ec2.Filter {
+				return input.(*opsee_aws_ec2.DescribeSecurityGroupsInput).Filters
+			},
+		},
+		{
+			name:  "route tables",
+			input: &opsee_aws_ec2.DescribeRouteTablesInput{},
+			filters: func(input interface{}) []*opsee_aws_ec2.Filter {
+				return input.(*opsee_aws_ec2.DescribeRouteTablesInput).Filters
+			},
+		},
+	}
+
+	req := &opsee.BezosRequest{User: testUser(), Region: "us-west-2", VpcId: "vpc-1"}
+
+	for _, test := range tests {
+		before := filterValues(test.filters(test.input))
+
+		scoped, vpcId, err := scopeInput(req, test.input, options{})
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if vpcId != "vpc-1" {
+			t.Errorf("%s: expected to be cached under vpc-1, got %s", test.name, vpcId)
+		}
+
+		values := filterValues(test.filters(scoped))
+		if !reflect.DeepEqual(values[vpcIdFilter], []string{"vpc-1"}) {
+			t.Errorf("%s: expected only vpc-1, got %v", test.name, values[vpcIdFilter])
+		}
+
+		for name, v := range before {
+			if name != vpcIdFilter && !reflect.DeepEqual(values[name], v) {
+				t.Errorf("%s: expected %s filter to be kept, got %v", test.name, name, values[name])
+			}
+		}
+
+		// the caller's input is left as it was
+		if !reflect.DeepEqual(filterValues(test.filters(test.input)), before) {
+			t.Errorf("%s: caller's input was modified", test.name)
+		}
+	}
+}
+
+func TestScopeInputUnfiltered(t *testing.T) {
+	req := &opsee.BezosRequest{User: testUser(), Region: "us-west-2", VpcId: "vpc-1"}
+	input := &opsee_aws_elb.DescribeLoadBalancersInput{}
+
+	scoped, vpcId, err := scopeInput(req, input, options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if scoped != input || vpcId != "vpc-1" {
+		t.Errorf("expected input to be sent as is under vpc-1, got %#v, %s", scoped, vpcId)
+	}
+}
+
+func TestScopeInputRegionWide(t *testing.T) {
+	input := &opsee_aws_ec2.DescribeInstancesInput{}
+
+	tests := []struct {
+		name    string
+		req     *opsee.BezosRequest
+		allowed bool
+	}{
+		{"user without flags", &opsee.BezosRequest{User: testUser(), VpcId: "vpc-1"}, false},
+		{"editor", &opsee.BezosRequest{User: testUser(PermEdit), VpcId: "vpc-1"}, false},
+		{"team admin", &opsee.BezosRequest{User: testUser(PermAdmin), VpcId: "vpc-1"}, true},
+		{"opsee admin", &opsee.BezosRequest{User: opseeAdmin(), VpcId: "vpc-1"}, true},
+	}
+
+	for _, test := range tests {
+		scoped, vpcId, err := scopeInput(test.req, input, options{regionWide: true})
+
+		if !test.allowed {
+			if err != ErrRegionWideDenied {
+				t.Errorf("%s: expected region wide to be denied, got %v", test.name, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if vpcId != regionWideVpcId || len(scoped.(*opsee_aws_ec2.DescribeInstancesInput).Filters) != 0 {
+			t.Errorf("%s: expected an unfiltered input under %s, got %#v, %s", test.name, regionWideVpcId, scoped, vpcId)
+		}
+	}
+}
+
+func TestScopeOutput(t *testing.T) {
+	lbs := func() *opsee_aws_elb.DescribeLoadBalancersOutput {
+		return &opsee_aws_elb.DescribeLoadBalancersOutput{LoadBalancerDescriptions: []*opsee_aws_elb.LoadBalancerDescription{
+			{LoadBalancerName: aws.String("in"), VPCId: aws.String("vpc-1")},
+			{LoadBalancerName: aws.String("out"), VPCId: aws.String("vpc-other")},
+			{LoadBalancerName: aws.String("classic")},
+		}}
+	}
+
+	dbs := func() *opsee_aws_rds.DescribeDBInstancesOutput {
+		return &opsee_aws_rds.DescribeDBInstancesOutput{DBInstances: []*opsee_aws_rds.DBInstance{
+			{DBInstanceIdentifier: aws.String("in"), DBSubnetGroup: &opsee_aws_rds.DBSubnetGroup{VpcId: aws.String("vpc-1")}},
+			{DBInstanceIdentifier: aws.String("out"), DBSubnetGroup: &opsee_aws_rds.DBSubnetGroup{VpcId: aws.String("vpc-other")}},
+			{DBInstanceIdentifier: aws.String("classic")},
+		}}
+	}
+
+	lbNames := func(output *opsee_aws_elb.DescribeLoadBalancersOutput) []string {
+		var names []string
+		for _, lb := range output.LoadBalancerDescriptions {
+			names = append(names, lb.GetLoadBalancerName())
+		}
+		return names
+	}
+
+	dbNames := func(output *opsee_aws_rds.DescribeDBInstancesOutput) []string {
+		var names []string
+		for _, db := range output.DBInstances {
+			names = append(names, db.GetDBInstanceIdentifier())
+		}
+		return names
+	}
+
+	scopedLbs := lbs()
+	scopeOutput(scopedLbs, "vpc-1")
+	if names := lbNames(scopedLbs); !reflect.DeepEqual(names, []string{"in"}) {
+		t.Errorf("expected only load balancers in vpc-1, got %v", names)
+	}
+
+	scopedDbs := dbs()
+	scopeOutput(scopedDbs, "vpc-1")
+	if names := dbNames(scopedDbs); !reflect.DeepEqual(names, []string{"in"}) {
+		t.Errorf("expected only db instances in vpc-1, got %v", names)
+	}
+
+	regionLbs := lbs()
+	scopeOutput(regionLbs, regionWideVpcId)
+	if names := lbNames(regionLbs); len(names) != 3 {
+		t.Errorf("expected region wide load balancers to be left alone, got %v", names)
+	}
+
+	regionDbs := dbs()
+	scopeOutput(regionDbs, regionWideVpcId)
+	if names := dbNames(regionDbs); len(names) != 3 {
+		t.Errorf("expected region wide db instances to be left alone, got %v", names)
+	}
+}
+
+func TestScopeCacheIsolation(t *testing.T) {
+	db := store.NewMemory(0, nil)
+	input := &opsee_aws_elb.DescribeLoadBalancersInput{}
+
+	admin := &opsee.BezosRequest{User: opseeAdmin(), Region: "us-west-2", VpcId: "vpc-1"}
+	user := &opsee.BezosRequest{User: testUser(), Region: "us-west-2", VpcId: "vpc-1"}
+
+	regionInput, regionVpcId, err := scopeInput(admin, input, options{regionWide: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scopedInput, scopedVpcId, err := scopeInput(user, input, options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	region := store.Request{
+		CustomerId: "customer",
+		Region:     "us-west-2",
+		VpcId:      regionVpcId,
+		Input:      regionInput,
+		Output: &opsee_aws_elb.DescribeLoadBalancersOutput{LoadBalancerDescriptions: []*opsee_aws_elb.LoadBalancerDescription{
+			{LoadBalancerName: aws.String("out"), VPCId: aws.String("vpc-other")},
+		}},
+	}
+
+	scoped := store.Request{
+		CustomerId: "customer",
+		Region:     "us-west-2",
+		VpcId:      scopedVpcId,
+		Input:      scopedInput,
+		Output:     &opsee_aws_elb.DescribeLoadBalancersOutput{},
+	}
+
+	regionKey, err := region.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scopedKey, err := scoped.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if regionKey == scopedKey {
+		t.Fatalf("region wide and scoped requests share key %s", regionKey)
+	}
+
+	if err := db.Put(region); err != nil {
+		t.Fatal(err)
+	}
+
+	if meta, err := db.Get(scoped); err == nil {
+		t.Errorf("scoped request was answered with the region wide resource: %#v", meta)
+	}
+}
@@ -7,8 +7,11 @@ import (
 	"fmt"
 	"net"
 	"reflect"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
@@ -16,6 +19,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/ecs"
 	"github.com/aws/aws-sdk-go/service/elb"
 	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/opsee/basic/schema"
 	opsee_aws "github.com/opsee/basic/schema/aws"
 	opsee_aws_autoscaling "github.com/opsee/basic/schema/aws/autoscaling"
 	opsee_aws_cloudwatch "github.com/opsee/basic/schema/aws/cloudwatch"
@@ -24,12 +28,24 @@ import (
 	opsee_aws_elb "github.com/opsee/basic/schema/aws/elb"
 	opsee_aws_rds "github.com/opsee/basic/schema/aws/rds"
 	opsee "github.com/opsee/basic/service"
+	"github.com/opsee/bezosphere/audit"
 	"github.com/opsee/bezosphere/store"
 	log "github.com/opsee/logrus"
-	"github.com/opsee/spanx/spanxcreds"
+	opsee_types "github.com/opsee/protobuf/opseeproto/types"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	grpcauth "google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	CacheTrailer     = "bezosphere-cache"
+	AgeTrailer       = "bezosphere-age"
+	RequestIdTrailer = "bezosphere-aws-request-id"
+	StaleTrailer     = "bezosphere-stale"
+
+	DefaultStaleIfError = 15 * time.Minute
 )
 
 var (
@@ -39,21 +55,211 @@ var (
 	ErrNoVpcId            = errors.New("request requires a vpc id, but none was given.")
 	ErrInvalidUser        = errors.New("user is invalid.")
 	ErrInvalidCredentials = errors.New("invalid AWS credentials.")
+	ErrLockingUnsupported = errors.New("replica locking requires a store that supports it.")
+	ErrUnsupportedInput   = errors.New("input type is not supported.")
 )
 
 type service struct {
-	spanxClient opsee.SpanxClient
-	db          store.Store
+	spanxClient      opsee.SpanxClient
+	db               store.Store
+	batchConcurrency int
+	maxBatchSize     int
+	watchInterval    time.Duration
+	watcher          *watcher
+	pageLimits       pageLimits
+	flight           *flightGroup
+	locker           store.Locker
+	sessions         *sessionPool
+	clientCAFile     string
+	allowedClients   map[string]bool
+	auditor          *auditor
+	limiter          *rateLimiter
+	breakers         *breakers
+	negativeTTLs     map[codes.Code]time.Duration
+	metrics          *serviceMetrics
+
+	policy            *store.Policy
+	staleIfError      time.Duration
+	maxRequestTimeout time.Duration
 }
 
 type Config struct {
-	SpanxAddress string
-	Db           store.Store
+	SpanxAddress     string
+	Db               store.Store
+	Janitor          *store.Janitor
+	BatchConcurrency int
+	MaxBatchSize     int
+	WatchInterval    time.Duration
+	MaxPages         int
+	MaxPageItems     int
+
+	// ReplicaLocking coordinates refreshes between replicas, if Db supports
+	// it, so only one of them talks to AWS for a given key at a time.
+	ReplicaLocking bool
+
+	// TTLPolicy sets the max age and max staleness of each request type, and
+	// which types aren't cached at all. Defaults to store.DefaultPolicy.
+	TTLPolicy *store.Policy
+
+	// StaleWhileRevalidate is how long past its max age a resource is still
+	// served straight from the cache while it's refreshed in the background,
+	// for request types the TTL policy doesn't give a max staleness. Zero
+	// turns it off.
+	StaleWhileRevalidate time.Duration
+
+	// StaleIfError is how long past its max age a resource can be served
+	// when AWS is throttling or unavailable, or the rate limiter or breaker
+	// turns the request away. Negative turns it off.
+	StaleIfError time.Duration
+
+	// MaxRequestTimeout bounds how long we'll spend on a request, including
+	// its calls to spanx and AWS, whatever deadline the client sent.
+	MaxRequestTimeout time.Duration
+
+	// CredentialExpiryWindow refreshes pooled AWS credentials this long
+	// before they expire. SessionIdleTimeout drops a customer's pooled
+	// session once it hasn't been used for that long.
+	CredentialExpiryWindow time.Duration
+	SessionIdleTimeout     time.Duration
+
+	// ClientCAFile is a bundle of CAs that clients' certificates must be
+	// signed by. Without it, clients aren't asked for a certificate.
+	// AllowedClients limits callers to the certificates with one of these
+	// common names or DNS or email subject alternative names.
+	ClientCAFile   string
+	AllowedClients []string
+
+	// Audit saves a record of every request answered on a customer's
+	// behalf, if it's set.
+	Audit audit.Store
+
+	// RateLimit is how many calls per second each customer can make to each
+	// AWS service in a region, with bursts of up to RateBurst. Every page and
+	// retry is a call. Requests over the limit are served stale from the
+	// cache if they can be.
+	RateLimit float64
+	RateBurst int
+
+	// BreakerThreshold is how many credential failures in a row it takes to
+	// stop calling AWS for a customer in a region, until BreakerCooldown
+	// has passed. Meanwhile requests are served stale from the cache if
+	// they can be.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	// NegativeTTLs is how long AWS errors are cached, by the status they're
+	// reported with. Defaults to DefaultNegativeTTLs, and statuses that
+	// aren't in it aren't cached.
+	NegativeTTLs map[codes.Code]time.Duration
 }
 
 func New(config Config) (*service, error) {
 	svc := &service{
-		db: config.Db,
+		db:               config.Db,
+		batchConcurrency: config.BatchConcurrency,
+		maxBatchSize:     config.MaxBatchSize,
+		watchInterval:    config.WatchInterval,
+		watcher:          newWatcher(),
+		pageLimits: pageLimits{
+			maxPages: config.MaxPages,
+			maxItems: config.MaxPageItems,
+		},
+		flight:            newFlightGroup(),
+		policy:            config.TTLPolicy,
+		staleIfError:      config.StaleIfError,
+		maxRequestTimeout: config.MaxRequestTimeout,
+		negativeTTLs:      config.NegativeTTLs,
+		clientCAFile:      config.ClientCAFile,
+		allowedClients:    make(map[string]bool),
+		metrics:           newServiceMetrics(config.Db, config.Janitor),
+	}
+
+	for _, client := range config.AllowedClients {
+		if client = strings.TrimSpace(client); client != "" {
+			svc.allowedClients[client] = true
+		}
+	}
+
+	// without a ca clients are never asked for a certificate, so nobody
+	// would be allowed
+	if len(svc.allowedClients) > 0 && svc.clientCAFile == "" {
+		return nil, ErrAllowlistWithoutCA
+	}
+
+	if svc.policy == nil {
+		svc.policy = store.DefaultPolicy()
+	}
+
+	if config.StaleWhileRevalidate > 0 {
+		svc.policy = svc.policy.WithMaxStale(config.StaleWhileRevalidate)
+	}
+
+	rateLimit := config.RateLimit
+	if rateLimit <= 0 {
+		rateLimit = DefaultRateLimit
+	}
+
+	rateBurst := config.RateBurst
+	if rateBurst <= 0 {
+		rateBurst = DefaultRateBurst
+	}
+
+	svc.limiter = newRateLimiter(rateLimit, rateBurst)
+
+	breakerThreshold := config.BreakerThreshold
+	if breakerThreshold <= 0 {
+		breakerThreshold = DefaultBreakerThreshold
+	}
+
+	breakerCooldown := config.BreakerCooldown
+	if breakerCooldown <= 0 {
+		breakerCooldown = DefaultBreakerCooldown
+	}
+
+	svc.breakers = newBreakers(breakerThreshold, breakerCooldown)
+
+	if config.Audit != nil {
+		svc.auditor = newAuditor(config.Audit, DefaultAuditBufferSize)
+	}
+
+	if svc.negativeTTLs == nil {
+		svc.negativeTTLs = DefaultNegativeTTLs()
+	}
+
+	if svc.staleIfError == 0 {
+		svc.staleIfError = DefaultStaleIfError
+	}
+
+	if svc.maxRequestTimeout <= 0 {
+		svc.maxRequestTimeout = DefaultMaxRequestTimeout
+	}
+
+	if config.ReplicaLocking {
+		locker, ok := config.Db.(store.Locker)
+		if !ok {
+			return nil, ErrLockingUnsupported
+		}
+		svc.locker = locker
+	}
+
+	if svc.batchConcurrency <= 0 {
+		svc.batchConcurrency = DefaultBatchConcurrency
+	}
+
+	if svc.maxBatchSize <= 0 {
+		svc.maxBatchSize = DefaultMaxBatchSize
+	}
+
+	if svc.watchInterval <= 0 {
+		svc.watchInterval = DefaultWatchInterval
+	}
+
+	if svc.pageLimits.maxPages <= 0 {
+		svc.pageLimits.maxPages = DefaultMaxPages
+	}
+
+	if svc.pageLimits.maxItems <= 0 {
+		svc.pageLimits.maxItems = DefaultMaxPageItems
 	}
 
 	spanxconn, err := grpc.Dial(
@@ -67,17 +273,33 @@ func New(config Config) (*service, error) {
 
 	svc.spanxClient = opsee.NewSpanxClient(spanxconn)
 
+	expiryWindow := config.CredentialExpiryWindow
+	if expiryWindow <= 0 {
+		expiryWindow = DefaultCredentialExpiryWindow
+	}
+
+	idleTimeout := config.SessionIdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = DefaultSessionIdleTimeout
+	}
+
+	svc.sessions = newSessionPool(svc.spanxClient, svc.maxRequestTimeout, expiryWindow, idleTimeout, svc.limiter, svc.metrics)
+
 	return svc, nil
 }
 
 func (s *service) Start(listenAddr, cert, certkey string) error {
-	auth, err := grpcauth.NewServerTLSFromFile(cert, certkey)
+	auth, err := serverCredentials(cert, certkey, s.clientCAFile)
 	if err != nil {
 		return err
 	}
 
-	server := grpc.NewServer(grpc.Creds(auth))
-	opsee.RegisterBezosServer(server, s)
+	server := grpc.NewServer(
+		grpc.Creds(auth),
+		grpc.UnaryInterceptor(s.unaryIdentity),
+		grpc.StreamInterceptor(s.streamIdentity),
+	)
+	RegisterBezosServer(server, s)
 
 	lis, err := net.Listen("tcp", listenAddr)
 	if err != nil {
@@ -89,21 +311,43 @@ func (s *service) Start(listenAddr, cert, certkey string) error {
 }
 
 func (s *service) Get(ctx context.Context, req *opsee.BezosRequest) (*opsee.BezosResponse, error) {
-	if req.Input == nil {
-		log.WithError(ErrNoInput).Errorf("invalid input %#v", req.Input)
-		return nil, ErrNoInput
+	logger, err := validateRequest(ctx, req)
+	if err != nil {
+		return nil, grpcError(ctx, err)
 	}
 
-	logger := log.WithField("input", reflect.TypeOf(req.Input).Elem().Name())
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
 
-	if req.User == nil {
-		logger.WithError(ErrNoUser).Error(ErrNoUser.Error())
-		return nil, ErrNoUser
+	opts := requestOptions(ctx)
+
+	res, err := s.resolve(ctx, logger, func() *session.Session {
+		return s.sessions.get(req.User, req.Region)
+	}, req, opts)
+	s.metrics.request("Get", req, err)
+	s.audit(ctx, "Get", req, opts, res, err)
+	if err != nil {
+		return nil, grpcError(ctx, err)
 	}
 
-	if err := req.User.Validate(); err != nil {
-		logger.WithError(err).Error(ErrInvalidUser.Error())
-		return nil, ErrInvalidUser
+	setProvenance(ctx, logger, res)
+	return res.response, nil
+}
+
+// validateRequest checks a request has everything we need to service it, and
+// returns a logger with the request's fields attached.
+func validateRequest(ctx context.Context, req *opsee.BezosRequest) (*log.Entry, error) {
+	logger := log.WithField("caller", callerFromContext(ctx))
+
+	if req.Input == nil {
+		logger.WithError(ErrNoInput).Errorf("invalid input %#v", req.Input)
+		return nil, ErrNoInput
+	}
+
+	logger = logger.WithField("input", reflect.TypeOf(req.Input).Elem().Name())
+
+	if err := validateScope(logger, req.User, req.Region, req.VpcId); err != nil {
+		return nil, err
 	}
 
 	logger = logger.WithFields(log.Fields{
@@ -111,17 +355,48 @@ func (s *service) Get(ctx context.Context, req *opsee.BezosRequest) (*opsee.Bezo
 		"user_id":     req.User.Id,
 	})
 
-	if req.Region == "" {
+	logger.Debug("valid grpc request")
+	return logger, nil
+}
+
+// validateScope checks the user, region and vpc shared by Get and BatchGet.
+func validateScope(logger *log.Entry, user *schema.User, region, vpcId string) error {
+	if user == nil {
+		logger.WithError(ErrNoUser).Error(ErrNoUser.Error())
+		return ErrNoUser
+	}
+
+	if err := user.Validate(); err != nil {
+		logger.WithError(err).Error(ErrInvalidUser.Error())
+		return ErrInvalidUser
+	}
+
+	if region == "" {
 		logger.WithError(ErrNoRegion).Error(ErrNoRegion.Error())
-		return nil, ErrNoRegion
+		return ErrNoRegion
 	}
 
-	if req.VpcId == "" {
+	if vpcId == "" {
 		logger.WithError(ErrNoVpcId).Error(ErrNoVpcId.Error())
-		return nil, ErrNoVpcId
+		return ErrNoVpcId
 	}
 
-	logger.Debug("valid grpc request")
+	return nil
+}
+
+// sessionFunc returns the AWS session to use on a cache miss. It's only called
+// when we actually need to talk to AWS.
+type sessionFunc func() *session.Session
+
+// resolution is a response along with where it came from.
+type resolution struct {
+	response *opsee.BezosResponse
+	cached   bool
+	meta     *store.Metadata
+}
+
+// resolve answers a validated request from the cache, or from AWS on a miss.
+func (s *service) resolve(ctx context.Context, logger *log.Entry, newSession sessionFunc, req *opsee.BezosRequest, opts options) (*resolution, error) {
 	bites, err := json.Marshal(req.Input)
 	if err != nil {
 		logger.WithError(err).Error("can't marshal request input")
@@ -135,21 +410,62 @@ func (s *service) Get(ctx context.Context, req *opsee.BezosRequest) (*opsee.Bezo
 		return nil, err
 	}
 
-	if shouldSkipCache(req.Input) {
+	if err := authorize(req.User, input); err != nil {
+		logger.WithError(err).Error(err.Error())
+		return nil, err
+	}
+
+	input, vpcId, err := scopeInput(req, input, opts)
+	if err != nil {
+		logger.WithError(err).Error("error scoping request")
+		return nil, err
+	}
+
+	var (
+		response  *opsee.BezosResponse
+		meta      *store.Metadata
+		cacheable = !s.policy.For(input).Disabled
+	)
+
+	storeRequest, err := s.policy.Apply(store.Request{
+		CustomerId: req.User.CustomerId,
+		Region:     req.Region,
+		VpcId:      vpcId,
+		Input:      input,
+		Output:     output,
+		MaxAge:     req.MaxAge,
+		AllPages:   opts.allPages,
+	})
+	if err != nil {
+		logger.WithError(err).Error("error applying ttl policy")
+		return nil, err
+	}
+
+	key, err := storeRequest.Key()
+	if err != nil {
+		logger.WithError(err).Error("error building cache key")
+		return nil, err
+	}
+
+	if !cacheable {
 		err = errors.New("input type not cached")
+		s.metrics.lookup(req, cacheDisabled)
 	} else {
-		err = s.db.Get(store.Request{
-			CustomerId: req.User.CustomerId,
-			Input:      input,
-			Output:     output,
-			MaxAge:     req.MaxAge,
-		})
+		meta, err = s.db.Get(storeRequest)
 	}
 
-	var response *opsee.BezosResponse
-
 	if err != nil {
 		logger.WithError(err).Error("cache miss")
+
+		if cacheable && store.IsExpired(err) {
+			s.metrics.lookup(req, cacheExpired)
+		} else if cacheable {
+			s.metrics.lookup(req, cacheMiss)
+		}
+	} else if meta.Error != nil {
+		logger.WithField("aws_error_code", meta.Error.Code).Debug("cached error hit")
+		s.metrics.lookup(req, cacheNegative)
+		return nil, replayError(meta)
 	} else {
 		logger.Debug("cache hit")
 
@@ -159,165 +475,403 @@ func (s *service) Get(ctx context.Context, req *opsee.BezosRequest) (*opsee.Bezo
 			return nil, err
 		}
 
-		return response, nil
+		if meta.Stale {
+			s.metrics.lookup(req, cacheStale)
+			logger.Debug("serving stale resource while revalidating")
+			go s.revalidate(logger, newSession, storeRequest, key, opts)
+		} else {
+			s.metrics.lookup(req, cacheHit)
+		}
+
+		response.LastModified = meta.UpdatedAt
+		return &resolution{response: response, cached: true, meta: meta}, nil
 	}
 
-	session := session.New(&aws.Config{
-		Region:      aws.String(req.Region),
-		Credentials: spanxcreds.NewSpanxCredentials(req.User, s.spanxClient),
-	})
+	// identical requests that miss at the same time share one trip to AWS
+	var (
+		v      interface{}
+		shared bool
+	)
+	for {
+		v, shared, err = s.flight.do(key, func() (interface{}, error) {
+			return s.fetch(ctx, logger, newSession, storeRequest, key, cacheable, opts)
+		})
 
-	err = dispatchRequest(ctx, logger, session, input, output)
+		// the request we shared with was cancelled, but we weren't
+		if shared && isCancellation(err) && ctx.Err() == nil {
+			continue
+		}
+		break
+	}
 	if err != nil {
+		if cacheable && s.staleIfError > 0 && fallsBackToStale(err) {
+			if res := s.staleFallback(logger, storeRequest); res != nil {
+				logger.WithError(err).Warn("serving stale resource instead")
+				return res, nil
+			}
+		}
+
 		return nil, err
 	}
 
-	err = s.db.Put(store.Request{
-		CustomerId: req.User.CustomerId,
-		Input:      input,
-		Output:     output,
-	})
-
-	if err != nil {
-		logger.WithError(err).Error("error saving to cache")
-		// just continue on
+	if shared {
+		logger.Debug("shared in-flight request")
 	}
 
-	response, err = buildResponse(output)
+	f := v.(*fetched)
+
+	response, err = buildResponse(f.output)
 	if err != nil {
 		logger.WithError(err).Error("no response found")
 		return nil, err
 	}
 
-	return response, nil
+	response.LastModified = f.meta.UpdatedAt
+	return &resolution{response: response, cached: f.cached, meta: f.meta}, nil
 }
 
-func shouldSkipCache(input interface{}) bool {
-	switch input.(type) {
-	case *opsee.BezosRequest_Cloudwatch_GetMetricStatisticsInput:
+// revalidate refreshes a stale resource in the background. It runs detached
+// from the request that found it stale, since that request has already been
+// answered.
+func (s *service) revalidate(logger *log.Entry, newSession sessionFunc, storeRequest store.Request, key string, opts options) {
+	// the stale output is still being sent to the caller, don't write over it
+	storeRequest.Output = newOutput(storeRequest.Output)
+
+	ctx, cancel := s.withTimeout(context.Background())
+	defer cancel()
+
+	_, _, err := s.flight.do(key, func() (interface{}, error) {
+		return s.fetch(ctx, logger, newSession, storeRequest, key, true, opts)
+	})
+
+	if isCancellation(err) {
+		logger.WithError(err).Warn("revalidating stale resource timed out")
+	} else if err != nil {
+		logger.WithError(err).Error("error revalidating stale resource")
+	}
+}
+
+// fallsBackToStale returns true if a failed fetch can be answered from the
+// stale-if-error window: AWS throttled us, was down or timed out, or our own
+// rate limiter or breaker turned the request away. Any other error is AWS's
+// answer, and serving the old resource would hide it.
+func fallsBackToStale(err error) bool {
+	if err == ErrRateLimited || err == ErrCircuitOpen {
 		return true
 	}
+
+	switch statusOf(err).code {
+	case codes.ResourceExhausted, codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	}
+
 	return false
 }
 
-func dispatchRequest(ctx context.Context, logger *log.Entry, session *session.Session, input interface{}, output interface{}) error {
+// staleFallback looks for anything in the cache within the stale-if-error
+// grace window. It returns nil if there's nothing usable.
+func (s *service) staleFallback(logger *log.Entry, storeRequest store.Request) *resolution {
+	output := newOutput(storeRequest.Output)
+
+	storeRequest.Output = output
+	storeRequest.MaxStale = s.staleIfError
+
+	meta, err := s.db.Get(storeRequest)
+	if err != nil || meta.Error != nil {
+		return nil
+	}
+
+	response, err := buildResponse(output)
+	if err != nil {
+		return nil
+	}
+
+	// whatever we found, it's older than the caller wanted
+	meta.Stale = true
+	response.LastModified = meta.UpdatedAt
+
+	return &resolution{response: response, cached: true, meta: meta}
+}
+
+// fetched is the outcome of a cache miss. It's usually fresh from AWS, but
+// can be cached if another replica refreshed it while we waited on the lock.
+type fetched struct {
+	output interface{}
+	meta   *store.Metadata
+	cached bool
+}
+
+// fetch gets a request from AWS and saves it to the cache. With replica
+// locking on, only one bezosphere refreshes a given key at a time.
+func (s *service) fetch(ctx context.Context, logger *log.Entry, newSession sessionFunc, storeRequest store.Request, key string, cacheable bool, opts options) (*fetched, error) {
+	if s.locker != nil {
+		unlock, err := s.locker.Lock(ctx, key)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+
+			logger.WithError(err).Warn("couldn't take refresh lock, fetching anyway")
+		} else {
+			defer func() {
+				if err := unlock(); err != nil {
+					logger.WithError(err).Error("error releasing refresh lock")
+				}
+			}()
+
+			if cacheable {
+				fresh := storeRequest
+				fresh.MaxStale = 0
+
+				if meta, err := s.db.Get(fresh); err == nil {
+					logger.Debug("refreshed by another replica")
+
+					if meta.Error != nil {
+						return nil, replayError(meta)
+					}
+					return &fetched{output: storeRequest.Output, meta: meta, cached: true}, nil
+				}
+			}
+		}
+	}
+
+	if !s.breakers.allow(storeRequest.CustomerId, storeRequest.Region) {
+		logger.WithError(ErrCircuitOpen).Debug(ErrCircuitOpen.Error())
+		return nil, ErrCircuitOpen
+	}
+
+	// the session's rate limiter can turn this away too, which gives up the
+	// breaker's probe if this was it
+	requestId, err := s.dispatch(ctx, logger, newSession(), storeRequest.Input, storeRequest.Output, s.limits(opts))
+	s.breakers.record(storeRequest.CustomerId, storeRequest.Region, err)
+	if err != nil {
+		if cacheable {
+			s.cacheError(logger, storeRequest, err)
+		}
+
+		return nil, err
+	}
+
+	scopeOutput(storeRequest.Output, storeRequest.VpcId)
+
+	meta := &store.Metadata{
+		UpdatedAt:    &opsee_types.Timestamp{},
+		AWSRequestId: requestId,
+	}
+	meta.UpdatedAt.Scan(time.Now().UTC())
+
+	storeRequest.MaxAge = nil
+	storeRequest.MaxStale = 0
+	storeRequest.AWSRequestId = requestId
+
+	err = s.db.Put(storeRequest)
+	if err != nil {
+		logger.WithError(err).Error("error saving to cache")
+		// just continue on
+	}
+
+	return &fetched{output: storeRequest.Output, meta: meta}, nil
+}
+
+// setProvenance tells the caller where a response came from, using trailing
+// metadata since BezosResponse only has room for last_modified.
+func setProvenance(ctx context.Context, logger *log.Entry, res *resolution) {
+	if err := grpc.SetTrailer(ctx, res.provenance()); err != nil {
+		logger.WithError(err).Warn("couldn't set response trailer")
+	}
+}
+
+// provenance is the trailing metadata setProvenance sends.
+func (res *resolution) provenance() metadata.MD {
+	cache := "miss"
+	if res.cached {
+		cache = "hit"
+	}
+
+	return metadata.Pairs(
+		CacheTrailer, cache,
+		AgeTrailer, strconv.FormatInt(res.age(), 10),
+		RequestIdTrailer, res.meta.AWSRequestId,
+		StaleTrailer, strconv.FormatBool(res.meta.Stale),
+	)
+}
+
+// newOutput returns an empty output of the same type as output.
+func newOutput(output interface{}) interface{} {
+	return reflect.New(reflect.TypeOf(output).Elem()).Interface()
+}
+
+// age is how old the response is, in seconds.
+func (res *resolution) age() int64 {
+	if res.meta.UpdatedAt == nil {
+		return 0
+	}
+
+	age := (time.Now().UTC().UnixNano()/1e6 - res.meta.UpdatedAt.Millis()) / 1000
+	if age < 0 {
+		return 0
+	}
+
+	return age
+}
+
+// limits returns the pagination limits to dispatch with, or nil if the
+// request only wants a single page.
+func (s *service) limits(opts options) *pageLimits {
+	if !opts.allPages {
+		return nil
+	}
+
+	return &s.pageLimits
+}
+
+func dispatchRequest(ctx context.Context, logger *log.Entry, session *session.Session, input interface{}, output interface{}, limits *pageLimits) (string, error) {
 	var (
-		err       error
-		awsOutput interface{}
+		awsRequest *request.Request
+		awsOutput  interface{}
 	)
 
 	switch input.(type) {
 	case *opsee_aws_cloudwatch.ListMetricsInput:
 		ipt := &cloudwatch.ListMetricsInput{}
 		opsee_aws.CopyInto(ipt, input)
-		awsOutput, err = cloudwatch.New(session).ListMetrics(ipt)
+		awsRequest, awsOutput = cloudwatch.New(session).ListMetricsRequest(ipt)
 
 	case *opsee_aws_cloudwatch.GetMetricStatisticsInput:
 		ipt := &cloudwatch.GetMetricStatisticsInput{}
 		opsee_aws.CopyInto(ipt, input)
-		awsOutput, err = cloudwatch.New(session).GetMetricStatistics(ipt)
+		awsRequest, awsOutput = cloudwatch.New(session).GetMetricStatisticsRequest(ipt)
 
 	case *opsee_aws_ec2.DescribeInstancesInput:
 		ipt := &ec2.DescribeInstancesInput{}
 		opsee_aws.CopyInto(ipt, input)
-		awsOutput, err = ec2.New(session).DescribeInstances(ipt)
+		awsRequest, awsOutput = ec2.New(session).DescribeInstancesRequest(ipt)
 
 	case *opsee_aws_ec2.DescribeSecurityGroupsInput:
 		ipt := &ec2.DescribeSecurityGroupsInput{}
 		opsee_aws.CopyInto(ipt, input)
-		awsOutput, err = ec2.New(session).DescribeSecurityGroups(ipt)
+		awsRequest, awsOutput = ec2.New(session).DescribeSecurityGroupsRequest(ipt)
 
 	case *opsee_aws_ec2.DescribeSubnetsInput:
 		ipt := &ec2.DescribeSubnetsInput{}
 		opsee_aws.CopyInto(ipt, input)
-		awsOutput, err = ec2.New(session).DescribeSubnets(ipt)
+		awsRequest, awsOutput = ec2.New(session).DescribeSubnetsRequest(ipt)
 
 	case *opsee_aws_ec2.DescribeVpcsInput:
 		ipt := &ec2.DescribeVpcsInput{}
 		opsee_aws.CopyInto(ipt, input)
-		awsOutput, err = ec2.New(session).DescribeVpcs(ipt)
+		awsRequest, awsOutput = ec2.New(session).DescribeVpcsRequest(ipt)
 
 	case *opsee_aws_ec2.DescribeRouteTablesInput:
 		ipt := &ec2.DescribeRouteTablesInput{}
 		opsee_aws.CopyInto(ipt, input)
-		awsOutput, err = ec2.New(session).DescribeRouteTables(ipt)
+		awsRequest, awsOutput = ec2.New(session).DescribeRouteTablesRequest(ipt)
 
 	case *opsee_aws_elb.DescribeLoadBalancersInput:
 		ipt := &elb.DescribeLoadBalancersInput{}
 		opsee_aws.CopyInto(ipt, input)
-		awsOutput, err = elb.New(session).DescribeLoadBalancers(ipt)
+		awsRequest, awsOutput = elb.New(session).DescribeLoadBalancersRequest(ipt)
 
 	case *opsee_aws_autoscaling.DescribeAutoScalingGroupsInput:
 		ipt := &autoscaling.DescribeAutoScalingGroupsInput{}
 		opsee_aws.CopyInto(ipt, input)
-		awsOutput, err = autoscaling.New(session).DescribeAutoScalingGroups(ipt)
+		awsRequest, awsOutput = autoscaling.New(session).DescribeAutoScalingGroupsRequest(ipt)
 
 	case *opsee_aws_rds.DescribeDBInstancesInput:
 		ipt := &rds.DescribeDBInstancesInput{}
 		opsee_aws.CopyInto(ipt, input)
-		awsOutput, err = rds.New(session).DescribeDBInstances(ipt)
+		awsRequest, awsOutput = rds.New(session).DescribeDBInstancesRequest(ipt)
 
 	case *opsee_aws_ecs.ListTasksInput:
 		ipt := &ecs.ListTasksInput{}
 		opsee_aws.CopyInto(ipt, input)
-		awsOutput, err = ecs.New(session).ListTasks(ipt)
+		awsRequest, awsOutput = ecs.New(session).ListTasksRequest(ipt)
 
 	case *opsee_aws_ecs.DescribeTasksInput:
 		ipt := &ecs.DescribeTasksInput{}
 		opsee_aws.CopyInto(ipt, input)
-		awsOutput, err = ecs.New(session).DescribeTasks(ipt)
+		awsRequest, awsOutput = ecs.New(session).DescribeTasksRequest(ipt)
 
 	case *opsee_aws_ecs.DescribeContainerInstancesInput:
 		ipt := &ecs.DescribeContainerInstancesInput{}
 		opsee_aws.CopyInto(ipt, input)
-		awsOutput, err = ecs.New(session).DescribeContainerInstances(ipt)
+		awsRequest, awsOutput = ecs.New(session).DescribeContainerInstancesRequest(ipt)
 
 	case *opsee_aws_ecs.ListContainerInstancesInput:
 		ipt := &ecs.ListContainerInstancesInput{}
 		opsee_aws.CopyInto(ipt, input)
-		awsOutput, err = ecs.New(session).ListContainerInstances(ipt)
+		awsRequest, awsOutput = ecs.New(session).ListContainerInstancesRequest(ipt)
 
 	case *opsee_aws_ecs.ListClustersInput:
 		ipt := &ecs.ListClustersInput{}
 		opsee_aws.CopyInto(ipt, input)
-		awsOutput, err = ecs.New(session).ListClusters(ipt)
+		awsRequest, awsOutput = ecs.New(session).ListClustersRequest(ipt)
 
 	case *opsee_aws_ecs.ListServicesInput:
 		ipt := &ecs.ListServicesInput{}
 		opsee_aws.CopyInto(ipt, input)
-		awsOutput, err = ecs.New(session).ListServices(ipt)
+		awsRequest, awsOutput = ecs.New(session).ListServicesRequest(ipt)
 
 	case *opsee_aws_ecs.DescribeServicesInput:
 		ipt := &ecs.DescribeServicesInput{}
 		opsee_aws.CopyInto(ipt, input)
-		awsOutput, err = ecs.New(session).DescribeServices(ipt)
+		awsRequest, awsOutput = ecs.New(session).DescribeServicesRequest(ipt)
 
 	case *opsee_aws_ecs.DescribeTaskDefinitionInput:
 		ipt := &ecs.DescribeTaskDefinitionInput{}
 		opsee_aws.CopyInto(ipt, input)
-		awsOutput, err = ecs.New(session).DescribeTaskDefinition(ipt)
+		awsRequest, awsOutput = ecs.New(session).DescribeTaskDefinitionRequest(ipt)
 
 	case *opsee_aws_cloudwatch.DescribeAlarmsInput:
 		ipt := &cloudwatch.DescribeAlarmsInput{}
 		opsee_aws.CopyInto(ipt, input)
-		awsOutput, err = cloudwatch.New(session).DescribeAlarms(ipt)
+		awsRequest, awsOutput = cloudwatch.New(session).DescribeAlarmsRequest(ipt)
 
 	case *opsee_aws_cloudwatch.DescribeAlarmsForMetricInput:
 		ipt := &cloudwatch.DescribeAlarmsForMetricInput{}
 		opsee_aws.CopyInto(ipt, input)
-		awsOutput, err = cloudwatch.New(session).DescribeAlarmsForMetric(ipt)
+		awsRequest, awsOutput = cloudwatch.New(session).DescribeAlarmsForMetricRequest(ipt)
 
 	default:
-		return fmt.Errorf("input type not found: %#v", input)
+		return "", ErrUnsupportedInput
+	}
+
+	withContext(ctx, awsRequest)
+
+	// otherwise the request would sit in signing until spanx answers
+	if err := awaitCredentials(ctx, awsRequest); err != nil {
+		if isCancellation(err) {
+			logger.WithError(err).Warn("aws request cancelled waiting for credentials")
+		} else {
+			logger.WithError(err).Error("error getting aws credentials")
+		}
+		return "", err
+	}
+
+	var err error
+	if limits != nil {
+		err = sendAllPages(logger, awsRequest, limits)
+	} else {
+		err = awsRequest.Send()
+	}
+
+	if isCancellation(err) {
+		logger.WithError(err).Warn("aws request cancelled")
+		return awsRequest.RequestID, err
+	}
+
+	if err == ErrRateLimited {
+		logger.WithError(err).Warn(err.Error())
+		return awsRequest.RequestID, err
 	}
 
 	if err != nil {
 		logger.WithError(err).Error("aws request error")
-		return err
+		return awsRequest.RequestID, err
 	}
 
 	opsee_aws.CopyInto(output, awsOutput)
-	return nil
+	return awsRequest.RequestID, nil
 }
 
 func inputOutput(ipt interface{}) (interface{}, interface{}, error) {
@@ -408,7 +962,7 @@ func inputOutput(ipt interface{}) (interface{}, interface{}, error) {
 		output = &opsee_aws_cloudwatch.DescribeAlarmsForMetricOutput{}
 
 	default:
-		return nil, nil, fmt.Errorf("input type not found: %#v", ipt)
+		return nil, nil, ErrUnsupportedInput
 	}
 
 	return input, output, nil
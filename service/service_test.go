@@ -0,0 +1,162 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	opsee_aws_ec2 "github.com/opsee/basic/schema/aws/ec2"
+	opsee_aws_ecs "github.com/opsee/basic/schema/aws/ecs"
+	"github.com/opsee/bezosphere/store"
+	log "github.com/opsee/logrus"
+	opsee_types "github.com/opsee/protobuf/opseeproto/types"
+	"golang.org/x/net/context"
+)
+
+func TestFallsBackToStale(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		fallback bool
+	}{
+		{"throttled", awserr.New("Throttling", "slow down", nil), true},
+		{"server error", awserr.NewRequestFailure(awserr.New("InternalError", "oops", nil), 503, "request-1"), true},
+		{"network error", awserr.New("RequestError", "send request failed", nil), true},
+		{"timed out", context.DeadlineExceeded, true},
+		{"rate limited", ErrRateLimited, true},
+		{"circuit open", ErrCircuitOpen, true},
+		{"not found", awserr.NewRequestFailure(awserr.New("InvalidInstanceID.NotFound", "gone", nil), 400, "request-1"), false},
+		{"invalid argument", awserr.New("InvalidParameterValue", "bad", nil), false},
+		{"access denied", awserr.New("AccessDenied", "no", nil), false},
+		{"cancelled", context.Canceled, false},
+		{"unknown", errors.New("something else"), false},
+	}
+
+	for _, test := range tests {
+		if fallback := fallsBackToStale(test.err); fallback != test.fallback {
+			t.Errorf("%s: expected fallback %v, got %v", test.name, test.fallback, fallback)
+		}
+	}
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	policy := store.DefaultPolicy()
+	policy.Types["ec2.DescribeVpcs"] = store.TTL{MaxAge: time.Hour, MaxStale: 6 * time.Hour}
+	policy.Types["ecs.ListTasks"] = store.TTL{MaxAge: 15 * time.Second}
+
+	svc, err := New(Config{
+		SpanxAddress:         "localhost:9095",
+		Db:                   store.NewMemory(0, policy),
+		TTLPolicy:            policy,
+		StaleWhileRevalidate: time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ttl := svc.policy.For(&opsee_aws_ec2.DescribeInstancesInput{}); ttl.MaxStale != time.Minute {
+		t.Errorf("expected types without a max staleness to get a minute, got %v", ttl.MaxStale)
+	}
+
+	if ttl := svc.policy.For(&opsee_aws_ecs.ListTasksInput{}); ttl.MaxStale != time.Minute || ttl.MaxAge != 15*time.Second {
+		t.Errorf("expected types with only a max age to get a minute, got %#v", ttl)
+	}
+
+	if ttl := svc.policy.For(&opsee_aws_ec2.DescribeVpcsInput{}); ttl.MaxStale != 6*time.Hour {
+		t.Errorf("expected the policy's own max staleness to be kept, got %v", ttl.MaxStale)
+	}
+
+	if policy.Default.MaxStale != 0 || policy.Types["ecs.ListTasks"].MaxStale != 0 {
+		t.Error("expected the caller's policy to be left alone")
+	}
+}
+
+func TestProvenance(t *testing.T) {
+	fake := newFakeAWS(vpcs("vpc-1"))
+	defer fake.Close()
+
+	s := fakeService(t, fake)
+
+	miss, err := s.resolve(context.Background(), log.WithField("test", "miss"), fake.session, vpcsRequest(), options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hit, err := s.resolve(context.Background(), log.WithField("test", "hit"), fake.session, vpcsRequest(), options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stale := &resolution{
+		response: vpcsResponse("vpc-1"),
+		cached:   true,
+		meta: &store.Metadata{
+			UpdatedAt:    timestamp(t, time.Now().Add(-2*time.Minute)),
+			AWSRequestId: "request-1",
+			Stale:        true,
+		},
+	}
+	stale.response.LastModified = stale.meta.UpdatedAt
+
+	for _, test := range []struct {
+		name     string
+		res      *resolution
+		trailers map[string]string
+	}{
+		{
+			name: "miss",
+			res:  miss,
+			trailers: map[string]string{
+				CacheTrailer:     "miss",
+				AgeTrailer:       "0",
+				RequestIdTrailer: "",
+				StaleTrailer:     "false",
+			},
+		},
+		{
+			name: "hit",
+			res:  hit,
+			trailers: map[string]string{
+				CacheTrailer:     "hit",
+				AgeTrailer:       "0",
+				RequestIdTrailer: "",
+				StaleTrailer:     "false",
+			},
+		},
+		{
+			name: "stale",
+			res:  stale,
+			trailers: map[string]string{
+				CacheTrailer:     "hit",
+				AgeTrailer:       "120",
+				RequestIdTrailer: "request-1",
+				StaleTrailer:     "true",
+			},
+		},
+	} {
+		md := test.res.provenance()
+
+		for key, expected := range test.trailers {
+			if values := md[key]; len(values) != 1 || values[0] != expected {
+				t.Errorf("%s: expected %s to be %q, got %q", test.name, key, expected, values)
+			}
+		}
+
+		if test.res.response.LastModified == nil || test.res.response.LastModified.Millis() != test.res.meta.UpdatedAt.Millis() {
+			t.Errorf("%s: expected last_modified to be when the resource was updated, got %v", test.name, test.res.response.LastModified)
+		}
+	}
+
+	if miss.meta.UpdatedAt.Millis() != hit.meta.UpdatedAt.Millis() {
+		t.Errorf("expected a hit to be as old as the miss that cached it, got %v and %v", hit.meta.UpdatedAt, miss.meta.UpdatedAt)
+	}
+}
+
+func timestamp(t *testing.T, tm time.Time) *opsee_types.Timestamp {
+	ts := &opsee_types.Timestamp{}
+	if err := ts.Scan(tm.UTC()); err != nil {
+		t.Fatal(err)
+	}
+	return ts
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/opsee/basic/schema"
+	opsee "github.com/opsee/basic/service"
+)
+
+const (
+	DefaultCredentialExpiryWindow = 5 * time.Minute
+	DefaultSessionIdleTimeout     = 30 * time.Minute
+)
+
+// sessionPool keeps one AWS session per customer and region, so credentials
+// from spanx are reused until they expire instead of being fetched on every
+// cache miss. Sessions nobody has used for idleTimeout are dropped.
+type sessionPool struct {
+	sync.Mutex
+
+	client        opsee.SpanxClient
+	spanxTimeout  time.Duration
+	expiryWindow  time.Duration
+	idleTimeout   time.Duration
+	sessions      map[sessionKey]*pooledSession
+	lastEvictedAt time.Time
+	limiter       *rateLimiter
+	metrics       *serviceMetrics
+}
+
+type sessionKey struct {
+	customerId string
+	region     string
+}
+
+type pooledSession struct {
+	session  *session.Session
+	lastUsed time.Time
+}
+
+func newSessionPool(client opsee.SpanxClient, spanxTimeout, expiryWindow, idleTimeout time.Duration, limiter *rateLimiter, metrics *serviceMetrics) *sessionPool {
+	return &sessionPool{
+		client:        client,
+		spanxTimeout:  spanxTimeout,
+		expiryWindow:  expiryWindow,
+		idleTimeout:   idleTimeout,
+		sessions:      make(map[sessionKey]*pooledSession),
+		lastEvictedAt: time.Now(),
+		limiter:       limiter,
+		metrics:       metrics,
+	}
+}
+
+// get returns the session for a user's customer in a region, creating it if
+// there isn't one. Credentials are fetched lazily, the first time the session
+// signs a request, and every call it makes is charged to the customer's rate
+// limit.
+func (p *sessionPool) get(user *schema.User, region string) *session.Session {
+	p.Lock()
+	defer p.Unlock()
+
+	now := time.Now()
+	if now.Sub(p.lastEvictedAt) > p.idleTimeout {
+		p.evict(now)
+	}
+
+	key := sessionKey{customerId: user.CustomerId, region: region}
+
+	pooled, ok := p.sessions[key]
+	if !ok {
+		pooled = &pooledSession{
+			session: session.New(&aws.Config{
+				Region:      aws.String(region),
+				Credentials: newSpanxCredentials(user, p.client, p.spanxTimeout, p.expiryWindow, p.metrics),
+				Retryer:     newRetryer(),
+			}),
+		}
+		p.limiter.limit(user.CustomerId, region, &pooled.session.Handlers)
+		p.sessions[key] = pooled
+	}
+
+	pooled.lastUsed = now
+	return pooled.session
+}
+
+// evict drops idle sessions. It must be called with the pool locked.
+func (p *sessionPool) evict(now time.Time) {
+	for key, pooled := range p.sessions {
+		if now.Sub(pooled.lastUsed) > p.idleTimeout {
+			delete(p.sessions, key)
+		}
+	}
+
+	p.lastEvictedAt = now
+}
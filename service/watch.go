@@ -0,0 +1,353 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	opsee "github.com/opsee/basic/service"
+	"github.com/opsee/bezosphere/store"
+	log "github.com/opsee/logrus"
+	opsee_types "github.com/opsee/protobuf/opseeproto/types"
+	"golang.org/x/net/context"
+)
+
+const (
+	DefaultWatchInterval = 30 * time.Second
+)
+
+// Watch sends the current state of a resource, then a new response every time
+// it changes. It returns when the client goes away.
+func (s *service) Watch(req *opsee.BezosRequest, stream Bezos_WatchServer) error {
+	ctx := stream.Context()
+
+	logger, err := validateRequest(ctx, req)
+	if err != nil {
+		return grpcError(ctx, err)
+	}
+
+	input, output, err := inputOutput(req.Input)
+	if err != nil {
+		logger.WithError(err).Error("error finding output")
+		return grpcError(ctx, err)
+	}
+
+	if err := authorize(req.User, input); err != nil {
+		logger.WithError(err).Error(err.Error())
+		return grpcError(ctx, err)
+	}
+
+	opts := requestOptions(ctx)
+
+	input, vpcId, err := scopeInput(req, input, opts)
+	if err != nil {
+		logger.WithError(err).Error("error scoping request")
+		return grpcError(ctx, err)
+	}
+
+	key, err := store.Request{
+		CustomerId: req.User.CustomerId,
+		Region:     req.Region,
+		VpcId:      vpcId,
+		Input:      input,
+		Output:     output,
+		AllPages:   opts.allPages,
+	}.Key()
+	if err != nil {
+		logger.WithError(err).Error("error building watch key")
+		return grpcError(ctx, err)
+	}
+
+	// subscribe first, so nothing that changes while we answer is missed
+	updates := s.watcher.subscribe(key, req, func(pollCtx context.Context) {
+		s.poll(pollCtx, key, opts)
+	})
+	defer s.watcher.unsubscribe(key, updates)
+
+	resolveCtx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	res, err := s.resolve(resolveCtx, logger, func() *session.Session {
+		return s.sessions.get(req.User, req.Region)
+	}, req, opts)
+	s.metrics.request("Watch", req, err)
+	s.audit(ctx, "Watch", req, opts, res, err)
+	if err != nil {
+		return grpcError(ctx, err)
+	}
+
+	s.watcher.sent(key, res.response)
+
+	if err := stream.Send(res.response); err != nil {
+		logger.WithError(err).Error("error sending watch response")
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Debug("watch closed by client")
+			return nil
+
+		case response := <-updates:
+			if err := stream.Send(response); err != nil {
+				logger.WithError(err).Error("error sending watch response")
+				return err
+			}
+		}
+	}
+}
+
+// poll refreshes a watched resource every watch interval until its context is
+// cancelled, broadcasting to subscribers whenever the output differs from the
+// last one they were sent. Each refresh is made on behalf of one of the
+// current subscribers, so it never outlives the user it runs as.
+func (s *service) poll(ctx context.Context, key string, opts options) {
+	logger := log.WithField("watch", key)
+
+	ticker := time.NewTicker(s.watchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Debug("stopping watch poller")
+			return
+
+		case <-ticker.C:
+			req, ok := s.watcher.request(key)
+			if !ok {
+				continue
+			}
+
+			logger := logger.WithFields(log.Fields{
+				"customer_id": req.User.CustomerId,
+				"user_id":     req.User.Id,
+			})
+
+			tickCtx, cancel := s.withTimeout(ctx)
+			response, err := s.refresh(tickCtx, logger, s.sessions.get(req.User, req.Region), req, opts)
+			cancel()
+
+			if isCancellation(err) {
+				logger.WithError(err).Warn("refreshing watched resource cancelled")
+				continue
+			}
+
+			if err != nil {
+				logger.WithError(err).Error("error refreshing watched resource")
+				continue
+			}
+
+			changed, err := s.watcher.broadcast(key, response)
+			if err != nil {
+				logger.WithError(err).Error("error comparing watched resource")
+				continue
+			}
+
+			if changed {
+				logger.Debug("watched resource changed")
+			}
+		}
+	}
+}
+
+// refresh fetches a resource from AWS, saving it in the cache if its type is
+// cached, and returns it as a response.
+func (s *service) refresh(ctx context.Context, logger *log.Entry, sess *session.Session, req *opsee.BezosRequest, opts options) (*opsee.BezosResponse, error) {
+	input, output, err := inputOutput(req.Input)
+	if err != nil {
+		return nil, err
+	}
+
+	input, vpcId, err := scopeInput(req, input, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	storeRequest := store.Request{
+		CustomerId: req.User.CustomerId,
+		Region:     req.Region,
+		VpcId:      vpcId,
+		Input:      input,
+		Output:     output,
+		AllPages:   opts.allPages,
+	}
+
+	if !s.breakers.allow(storeRequest.CustomerId, storeRequest.Region) {
+		return nil, ErrCircuitOpen
+	}
+
+	requestId, err := s.dispatch(ctx, logger, sess, input, output, s.limits(opts))
+	s.breakers.record(storeRequest.CustomerId, storeRequest.Region, err)
+	if err != nil {
+		return nil, err
+	}
+
+	scopeOutput(output, vpcId)
+
+	lastModified := &opsee_types.Timestamp{}
+	lastModified.Scan(time.Now().UTC())
+
+	storeRequest.AWSRequestId = requestId
+
+	if !s.policy.For(input).Disabled {
+		if err := s.db.Put(storeRequest); err != nil {
+			logger.WithError(err).Error("error saving to cache")
+			// just continue on
+		}
+	}
+
+	response, err := buildResponse(output)
+	if err != nil {
+		return nil, err
+	}
+
+	response.LastModified = lastModified
+	return response, nil
+}
+
+// sameOutput compares the outputs of two responses, ignoring when they were
+// last modified.
+func sameOutput(a, b *opsee.BezosResponse) (bool, error) {
+	ab, err := json.Marshal(a.Output)
+	if err != nil {
+		return false, err
+	}
+
+	bb, err := json.Marshal(b.Output)
+	if err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(ab, bb), nil
+}
+
+// watcher shares one poller between every subscriber watching the same
+// resource.
+type watcher struct {
+	sync.Mutex
+	pollers map[string]*poller
+}
+
+// poller keeps each subscriber's request, so it can refresh as any of them,
+// and the last response its subscribers were sent, to tell when the resource
+// has changed. Comparing with the cache instead would miss changes that
+// another request happened to cache first.
+type poller struct {
+	subscribers map[chan *opsee.BezosResponse]*opsee.BezosRequest
+	cancel      context.CancelFunc
+	last        *opsee.BezosResponse
+}
+
+func newWatcher() *watcher {
+	return &watcher{
+		pollers: make(map[string]*poller),
+	}
+}
+
+// subscribe returns a channel of updates for key, calling start in a new
+// goroutine if nobody else is watching it yet.
+func (w *watcher) subscribe(key string, req *opsee.BezosRequest, start func(context.Context)) chan *opsee.BezosResponse {
+	w.Lock()
+	defer w.Unlock()
+
+	p, ok := w.pollers[key]
+	if !ok {
+		ctx, cancel := context.WithCancel(context.Background())
+		p = &poller{
+			subscribers: make(map[chan *opsee.BezosResponse]*opsee.BezosRequest),
+			cancel:      cancel,
+		}
+		w.pollers[key] = p
+
+		go start(ctx)
+	}
+
+	updates := make(chan *opsee.BezosResponse, 1)
+	p.subscribers[updates] = req
+
+	return updates
+}
+
+// request returns the request of one of key's current subscribers, or false if
+// there aren't any.
+func (w *watcher) request(key string) (*opsee.BezosRequest, bool) {
+	w.Lock()
+	defer w.Unlock()
+
+	p, ok := w.pollers[key]
+	if !ok {
+		return nil, false
+	}
+
+	for _, req := range p.subscribers {
+		return req, true
+	}
+
+	return nil, false
+}
+
+// unsubscribe removes a subscriber, stopping the poller if it was the last.
+func (w *watcher) unsubscribe(key string, updates chan *opsee.BezosResponse) {
+	w.Lock()
+	defer w.Unlock()
+
+	p, ok := w.pollers[key]
+	if !ok {
+		return
+	}
+
+	delete(p.subscribers, updates)
+
+	if len(p.subscribers) == 0 {
+		p.cancel()
+		delete(w.pollers, key)
+	}
+}
+
+// sent records a response sent to a new subscriber of key, so the poller has
+// something to compare against if it hasn't sent anything yet.
+func (w *watcher) sent(key string, response *opsee.BezosResponse) {
+	w.Lock()
+	defer w.Unlock()
+
+	if p, ok := w.pollers[key]; ok && p.last == nil {
+		p.last = response
+	}
+}
+
+// broadcast hands a response to every subscriber of key, unless its output is
+// the same as the last one they were sent. Subscribers that haven't caught up
+// only ever get the latest response. It returns whether anything was sent.
+func (w *watcher) broadcast(key string, response *opsee.BezosResponse) (bool, error) {
+	w.Lock()
+	defer w.Unlock()
+
+	p, ok := w.pollers[key]
+	if !ok {
+		return false, nil
+	}
+
+	if p.last != nil {
+		same, err := sameOutput(p.last, response)
+		if err != nil || same {
+			return false, err
+		}
+	}
+
+	p.last = response
+
+	for updates := range p.subscribers {
+		select {
+		case <-updates:
+		default:
+		}
+
+		updates <- response
+	}
+
+	return true, nil
+}
@@ -0,0 +1,206 @@
+package service
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/opsee/basic/schema"
+	opsee_aws_ec2 "github.com/opsee/basic/schema/aws/ec2"
+	opsee "github.com/opsee/basic/service"
+	"github.com/opsee/bezosphere/store"
+	log "github.com/opsee/logrus"
+	"golang.org/x/net/context"
+)
+
+func TestWatcherRequest(t *testing.T) {
+	w := newWatcher()
+
+	started := make(chan struct{}, 2)
+	start := func(ctx context.Context) {
+		started <- struct{}{}
+	}
+
+	first := &opsee.BezosRequest{User: &schema.User{Id: 1, CustomerId: "customer"}}
+	second := &opsee.BezosRequest{User: &schema.User{Id: 2, CustomerId: "customer"}}
+
+	firstUpdates := w.subscribe("key", first, start)
+	secondUpdates := w.subscribe("key", second, start)
+
+	<-started
+	if len(started) != 0 {
+		t.Fatal("expected one poller for both subscribers")
+	}
+
+	w.unsubscribe("key", firstUpdates)
+
+	req, ok := w.request("key")
+	if !ok || req != second {
+		t.Errorf("expected the remaining subscriber's request, got %#v", req)
+	}
+
+	w.unsubscribe("key", secondUpdates)
+
+	if _, ok := w.request("key"); ok {
+		t.Error("expected no request once everyone unsubscribed")
+	}
+}
+
+func vpcsResponse(vpcIds ...string) *opsee.BezosResponse {
+	output := &opsee_aws_ec2.DescribeVpcsOutput{}
+	for _, vpcId := range vpcIds {
+		output.Vpcs = append(output.Vpcs, &opsee_aws_ec2.Vpc{VpcId: aws.String(vpcId)})
+	}
+
+	response, _ := buildResponse(output)
+	return response
+}
+
+func TestWatcherBroadcast(t *testing.T) {
+	w := newWatcher()
+
+	if changed, err := w.broadcast("key", vpcsResponse("vpc-1")); changed || err != nil {
+		t.Fatalf("expected nothing to be sent without subscribers, got %v, %v", changed, err)
+	}
+
+	updates := w.subscribe("key", vpcsRequest(), func(context.Context) {})
+	w.sent("key", vpcsResponse("vpc-1"))
+
+	if changed, _ := w.broadcast("key", vpcsResponse("vpc-1")); changed || len(updates) != 0 {
+		t.Error("expected the response the subscriber was sent not to be broadcast")
+	}
+
+	// a subscriber that hasn't caught up only gets the latest
+	w.broadcast("key", vpcsResponse("vpc-2"))
+	w.broadcast("key", vpcsResponse("vpc-3"))
+
+	if ids := vpcIds(<-updates); !reflect.DeepEqual(ids, []string{"vpc-3"}) {
+		t.Errorf("expected the latest response, got %v", ids)
+	}
+
+	// a later subscriber's first response doesn't replace what's been sent
+	w.subscribe("key", vpcsRequest(), func(context.Context) {})
+	w.sent("key", vpcsResponse("vpc-2"))
+
+	if changed, _ := w.broadcast("key", vpcsResponse("vpc-3")); changed {
+		t.Error("expected no broadcast of what was last sent")
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	fake := newFakeAWS(vpcs("vpc-1"))
+	defer fake.Close()
+
+	s := fakeService(t, fake)
+	req := vpcsRequest()
+
+	response, err := s.refresh(context.Background(), log.WithField("test", "refresh"), fake.session(), req, options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ids := vpcIds(response); !reflect.DeepEqual(ids, []string{"vpc-1"}) || response.LastModified == nil {
+		t.Errorf("unexpected response: %#v", response)
+	}
+
+	input, output, _ := inputOutput(req.Input)
+	input, vpcId, _ := scopeInput(req, input, options{})
+
+	meta, err := s.db.Get(store.Request{
+		CustomerId: "customer",
+		Region:     "us-west-2",
+		VpcId:      vpcId,
+		Input:      input,
+		Output:     output,
+	})
+	if err != nil || meta.Error != nil {
+		t.Errorf("expected the refresh to be cached, got %#v, %v", meta, err)
+	}
+
+	fake.answer(awsError(http.StatusBadRequest, "UnauthorizedOperation"))
+	if _, err := s.refresh(context.Background(), log.WithField("test", "refresh"), fake.session(), req, options{}); err == nil {
+		t.Error("expected an error from AWS")
+	}
+}
+
+// nextUpdate waits a while for a watch update.
+func nextUpdate(t *testing.T, updates chan *opsee.BezosResponse) *opsee.BezosResponse {
+	select {
+	case response := <-updates:
+		return response
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for an update")
+		return nil
+	}
+}
+
+func TestPoll(t *testing.T) {
+	fake := newFakeAWS(vpcs("vpc-1"))
+	defer fake.Close()
+
+	s := fakeService(t, fake)
+
+	updates := s.watcher.subscribe("key", vpcsRequest(), func(ctx context.Context) {
+		s.poll(ctx, "key", options{})
+	})
+	s.watcher.sent("key", vpcsResponse("vpc-1"))
+
+	// nothing's changed
+	for fake.requests() < 3 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if len(updates) != 0 {
+		t.Fatalf("expected no updates, got %v", vpcIds(<-updates))
+	}
+
+	fake.answer(vpcs("vpc-1", "vpc-2"))
+
+	if ids := vpcIds(nextUpdate(t, updates)); !reflect.DeepEqual(ids, []string{"vpc-1", "vpc-2"}) {
+		t.Errorf("expected the change, got %v", ids)
+	}
+
+	s.watcher.unsubscribe("key", updates)
+
+	calls := fake.requests()
+	time.Sleep(50 * time.Millisecond)
+	if fake.requests() > calls+1 {
+		t.Error("expected the poller to stop with its last subscriber")
+	}
+}
+
+func TestPollAfterConcurrentGet(t *testing.T) {
+	fake := newFakeAWS(vpcs("vpc-1"))
+	defer fake.Close()
+
+	s := fakeService(t, fake)
+	req := vpcsRequest()
+
+	// the poller can't run until the get has cached the change
+	start := make(chan struct{})
+	updates := s.watcher.subscribe("key", req, func(ctx context.Context) {
+		<-start
+		s.poll(ctx, "key", options{})
+	})
+	defer s.watcher.unsubscribe("key", updates)
+	s.watcher.sent("key", vpcsResponse("vpc-1"))
+
+	fake.answer(vpcs("vpc-2"))
+
+	res, err := s.resolve(context.Background(), log.WithField("test", "get"), fake.session, req, options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ids := vpcIds(res.response); !reflect.DeepEqual(ids, []string{"vpc-2"}) {
+		t.Fatalf("expected the get to fetch the change, got %v", ids)
+	}
+
+	close(start)
+
+	if ids := vpcIds(nextUpdate(t, updates)); !reflect.DeepEqual(ids, []string{"vpc-2"}) {
+		t.Errorf("expected watchers to get the change the get cached, got %v", ids)
+	}
+}
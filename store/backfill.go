@@ -0,0 +1,183 @@
+package store
+
+import (
+	"fmt"
+	"reflect"
+
+	log "github.com/opsee/logrus"
+)
+
+var (
+	DefaultBackfillBatchSize = 500
+)
+
+// Reencode rewrites every resource that isn't saved in encoding, batchSize
+// rows at a time, without changing when it was last updated. Outputs are
+// empty values of every output type that may be cached, they're used to
+// decode rows by response_type. Rows of an unknown type, or that change while
+// they're being rewritten, are left alone. It returns how many rows were
+// rewritten.
+func Reencode(s Store, encoding string, outputs []interface{}, batchSize int) (int64, error) {
+	p, ok := postgresStore(s)
+	if !ok {
+		return 0, errReencodeUnsupported
+	}
+
+	if !validEncoding(encoding) {
+		return 0, errUnknownEncoding
+	}
+
+	types := make(map[string]reflect.Type)
+	for _, output := range outputs {
+		t := reflect.TypeOf(output)
+		types[t.String()] = t.Elem()
+	}
+
+	// cached errors are always json
+	return p.rewrite(`encoding <> $1 and response_type <> $2`, []interface{}{encoding, errorResponseType}, batchSize, func(r *resource) error {
+		t, ok := types[r.ResponseType]
+		if !ok {
+			return errInvalidAWSOutput
+		}
+
+		output := reflect.New(t).Interface()
+		if err := decodeOutput(r, output); err != nil {
+			return err
+		}
+
+		data, blob, err := encodeOutput(output, encoding)
+		if err != nil {
+			return err
+		}
+
+		r.ResponseData = data
+		r.ResponseBlob = blob
+		r.Encoding = encoding
+
+		return nil
+	})
+}
+
+// Reencrypt rewrites every resource that isn't encrypted with its customer's
+// latest data key, batchSize rows at a time, see Reencode. Plaintext rows are
+// matched whether or not their customer has a key yet, encrypting them
+// creates one.
+func Reencrypt(s Store, batchSize int) (int64, error) {
+	p, ok := postgresStore(s)
+	if !ok {
+		return 0, errReencodeUnsupported
+	}
+
+	if p.keys == nil {
+		return 0, errMissingMasterKey
+	}
+
+	return p.rewrite(
+		`(key_version is null or key_version <> (select max(version) from customer_keys k where k.customer_id = resources.customer_id))`,
+		nil,
+		batchSize,
+		// encrypting the rewritten row picks up the latest key
+		func(r *resource) error { return nil },
+	)
+}
+
+// rewrite walks the resources matching filter in primary key order, batchSize
+// at a time. Each is decrypted, changed by fn, encrypted again and saved, so
+// long as it hasn't changed in the meantime.
+func (s *postgres) rewrite(filter string, args []interface{}, batchSize int, fn func(*resource) error) (int64, error) {
+	if batchSize <= 0 {
+		batchSize = DefaultBackfillBatchSize
+	}
+
+	n := len(args)
+	query := fmt.Sprintf(
+		`select * from resources where %s
+		 and (customer_id, region, vpc_id, id) > ($%d, $%d, $%d, $%d)
+		 order by customer_id, region, vpc_id, id limit $%d`,
+		filter, n+1, n+2, n+3, n+4, n+5,
+	)
+
+	var (
+		rewritten int64
+		last      = &resource{CustomerId: "00000000-0000-0000-0000-000000000000"}
+	)
+
+	for {
+		var batch []*resource
+
+		err := s.db.Select(&batch, query, append(args, last.CustomerId, last.Region, last.VpcId, last.Id, batchSize)...)
+		if err != nil {
+			return rewritten, err
+		}
+
+		for _, r := range batch {
+			ok, err := s.rewriteOne(r, fn)
+			if err != nil {
+				log.WithError(err).WithFields(log.Fields{
+					"customer_id":   r.CustomerId,
+					"id":            r.Id,
+					"response_type": r.ResponseType,
+				}).Warn("couldn't rewrite resource")
+				continue
+			}
+
+			if ok {
+				rewritten++
+			}
+		}
+
+		if len(batch) < batchSize {
+			return rewritten, nil
+		}
+
+		last = batch[len(batch)-1]
+		log.WithField("rewritten", rewritten).Info("rewrote batch of resources")
+	}
+}
+
+func (s *postgres) rewriteOne(r *resource, fn func(*resource) error) (bool, error) {
+	var (
+		encoding   = r.Encoding
+		keyVersion = r.KeyVersion
+		updatedAt  = r.UpdatedAt
+	)
+
+	if err := s.decrypt(r); err != nil {
+		return false, err
+	}
+
+	if err := fn(r); err != nil {
+		return false, err
+	}
+
+	if err := s.encrypt(r); err != nil {
+		return false, err
+	}
+
+	// the update trigger leaves updated_at alone when the encoding or key
+	// version changes
+	res, err := s.db.Exec(
+		`update resources set (request_data, request_blob, response_data, response_blob, encoding, key_version) = ($1, $2, $3, $4, $5, $6)
+		 where customer_id = $7 and region = $8 and vpc_id = $9 and id = $10
+		 and encoding = $11 and key_version is not distinct from $12 and updated_at = $13`,
+		r.RequestData,
+		r.RequestBlob,
+		r.ResponseData,
+		r.ResponseBlob,
+		r.Encoding,
+		r.KeyVersion,
+		r.CustomerId,
+		r.Region,
+		r.VpcId,
+		r.Id,
+		encoding,
+		keyVersion,
+		updatedAt,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,386 @@
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	// DefaultKeyCacheTTL is how long we trust our idea of a customer's
+	// latest data key version, before checking for a rotation.
+	DefaultKeyCacheTTL = 5 * time.Minute
+)
+
+// MasterKey wraps customers' data keys. Its id is saved with every key it
+// wraps, so that keys can still be unwrapped after the master key rotates.
+type MasterKey struct {
+	Id  string
+	Key []byte
+}
+
+// ParseMasterKeys reads a comma separated list of id:key entries, where each
+// key is 32 bytes of base64. The first wraps new data keys, the rest are only
+// used to unwrap keys wrapped before it, e.g.
+//
+//	2016-09:<base64 key>,2016-06:<base64 key>
+func ParseMasterKeys(s string) ([]MasterKey, error) {
+	var keys []MasterKey
+
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid master key entry, expected id:key")
+		}
+
+		key, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid master key %s: %s", parts[0], err)
+		}
+
+		if len(key) != 32 {
+			return nil, fmt.Errorf("invalid master key %s: expected 32 bytes, got %d", parts[0], len(key))
+		}
+
+		keys = append(keys, MasterKey{Id: parts[0], Key: key})
+	}
+
+	return keys, nil
+}
+
+// keyring manages customers' data keys, caching them unwrapped.
+type keyring struct {
+	sync.Mutex
+
+	db       *sqlx.DB
+	current  string
+	masters  map[string]cipher.AEAD
+	dataKeys map[dataKeyId]cipher.AEAD
+	latest   map[string]latestVersion
+}
+
+type dataKeyId struct {
+	customerId string
+	version    int
+}
+
+type latestVersion struct {
+	version   int
+	fetchedAt time.Time
+}
+
+type customerKey struct {
+	CustomerId  string    `db:"customer_id"`
+	Version     int       `db:"version"`
+	WrappedKey  []byte    `db:"wrapped_key"`
+	MasterKeyId string    `db:"master_key_id"`
+	CreatedAt   time.Time `db:"created_at"`
+}
+
+func newKeyring(db *sqlx.DB, masterKeys []MasterKey) (*keyring, error) {
+	k := &keyring{
+		db:       db,
+		current:  masterKeys[0].Id,
+		masters:  make(map[string]cipher.AEAD),
+		dataKeys: make(map[dataKeyId]cipher.AEAD),
+		latest:   make(map[string]latestVersion),
+	}
+
+	for _, master := range masterKeys {
+		aead, err := newAEAD(master.Key)
+		if err != nil {
+			return nil, err
+		}
+		k.masters[master.Id] = aead
+	}
+
+	return k, nil
+}
+
+// latestKey returns the key to encrypt a customer's resources with, creating
+// their first one if they don't have one yet.
+func (k *keyring) latestKey(customerId string) (int, cipher.AEAD, error) {
+	k.Lock()
+	latest, ok := k.latest[customerId]
+	k.Unlock()
+
+	if !ok || time.Since(latest.fetchedAt) > DefaultKeyCacheTTL {
+		version, err := k.latestVersion(customerId)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if version == 0 {
+			// if another replica beats us to it, we'll use theirs
+			if _, err := k.createKey(customerId, 1); err != nil {
+				return 0, nil, err
+			}
+
+			version, err = k.latestVersion(customerId)
+			if err != nil {
+				return 0, nil, err
+			}
+		}
+
+		latest = latestVersion{version: version, fetchedAt: time.Now()}
+
+		k.Lock()
+		k.latest[customerId] = latest
+		k.Unlock()
+	}
+
+	aead, err := k.key(customerId, latest.version)
+	return latest.version, aead, err
+}
+
+// key returns a version of a customer's data key.
+func (k *keyring) key(customerId string, version int) (cipher.AEAD, error) {
+	id := dataKeyId{customerId: customerId, version: version}
+
+	k.Lock()
+	aead, ok := k.dataKeys[id]
+	k.Unlock()
+
+	if ok {
+		return aead, nil
+	}
+
+	ck := &customerKey{}
+	err := k.db.Get(ck, `select * from customer_keys where customer_id = $1 and version = $2`, customerId, version)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err = k.unwrap(ck)
+	if err != nil {
+		return nil, err
+	}
+
+	k.Lock()
+	k.dataKeys[id] = aead
+	k.Unlock()
+
+	return aead, nil
+}
+
+// latestVersion returns a customer's latest key version, or 0 if they don't
+// have a key.
+func (k *keyring) latestVersion(customerId string) (int, error) {
+	var version sql.NullInt64
+
+	err := k.db.Get(&version, `select max(version) from customer_keys where customer_id = $1`, customerId)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(version.Int64), nil
+}
+
+// createKey generates a new data key for a customer with the given version,
+// wrapped by the current master key. It returns false if the version already
+// exists.
+func (k *keyring) createKey(customerId string, version int) (bool, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return false, err
+	}
+
+	wrapped, err := seal(k.masters[k.current], key, keyAAD(customerId, version))
+	if err != nil {
+		return false, err
+	}
+
+	res, err := k.db.Exec(
+		`insert into customer_keys (customer_id, version, wrapped_key, master_key_id) values ($1, $2, $3, $4)
+		 on conflict on constraint customer_keys_pkey do nothing`,
+		customerId,
+		version,
+		wrapped,
+		k.current,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// rotate gives a customer a new latest data key.
+func (k *keyring) rotate(customerId string) (int, error) {
+	for {
+		version, err := k.latestVersion(customerId)
+		if err != nil {
+			return 0, err
+		}
+
+		created, err := k.createKey(customerId, version+1)
+		if err != nil {
+			return 0, err
+		}
+
+		if created {
+			k.Lock()
+			delete(k.latest, customerId)
+			k.Unlock()
+
+			return version + 1, nil
+		}
+	}
+}
+
+// rewrap wraps every data key that isn't wrapped by the current master key
+// with it.
+func (k *keyring) rewrap() (int64, error) {
+	var keys []*customerKey
+
+	err := k.db.Select(&keys, `select * from customer_keys where master_key_id <> $1`, k.current)
+	if err != nil {
+		return 0, err
+	}
+
+	var rewrapped int64
+	for _, ck := range keys {
+		master, ok := k.masters[ck.MasterKeyId]
+		if !ok {
+			return rewrapped, fmt.Errorf("customer %s key version %d is wrapped by unknown master key %s", ck.CustomerId, ck.Version, ck.MasterKeyId)
+		}
+
+		aad := keyAAD(ck.CustomerId, ck.Version)
+
+		key, err := open(master, ck.WrappedKey, aad)
+		if err != nil {
+			return rewrapped, err
+		}
+
+		wrapped, err := seal(k.masters[k.current], key, aad)
+		if err != nil {
+			return rewrapped, err
+		}
+
+		_, err = k.db.Exec(
+			`update customer_keys set (wrapped_key, master_key_id) = ($1, $2) where customer_id = $3 and version = $4 and master_key_id = $5`,
+			wrapped,
+			k.current,
+			ck.CustomerId,
+			ck.Version,
+			ck.MasterKeyId,
+		)
+		if err != nil {
+			return rewrapped, err
+		}
+
+		rewrapped++
+	}
+
+	return rewrapped, nil
+}
+
+func (k *keyring) unwrap(ck *customerKey) (cipher.AEAD, error) {
+	master, ok := k.masters[ck.MasterKeyId]
+	if !ok {
+		return nil, errUnknownMasterKey
+	}
+
+	key, err := open(master, ck.WrappedKey, keyAAD(ck.CustomerId, ck.Version))
+	if err != nil {
+		return nil, err
+	}
+
+	return newAEAD(key)
+}
+
+// keyAAD binds a wrapped key to its customer and version, so it can't be
+// swapped for another.
+func keyAAD(customerId string, version int) []byte {
+	return []byte(customerId + "/" + strconv.Itoa(version))
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext with a random nonce, which is prepended to the
+// ciphertext.
+func seal(aead cipher.AEAD, plaintext, aad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func open(aead cipher.AEAD, ciphertext, aad []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errInvalidCiphertext
+	}
+
+	nonce := ciphertext[:aead.NonceSize()]
+	return aead.Open(nil, nonce, ciphertext[aead.NonceSize():], aad)
+}
+
+// RewrapKeys wraps every customer data key with the current master key, so
+// older master keys can be retired. It returns how many keys were rewrapped.
+func RewrapKeys(s Store) (int64, error) {
+	p, ok := postgresStore(s)
+	if !ok {
+		return 0, errReencodeUnsupported
+	}
+
+	if p.keys == nil {
+		return 0, errMissingMasterKey
+	}
+
+	return p.keys.rewrap()
+}
+
+// RotateKeys gives every customer with cached resources a new data key
+// version. Resources are still encrypted with the old versions until they're
+// updated, or rewritten by Reencrypt. It returns how many keys were created.
+func RotateKeys(s Store) (int64, error) {
+	p, ok := postgresStore(s)
+	if !ok {
+		return 0, errReencodeUnsupported
+	}
+
+	if p.keys == nil {
+		return 0, errMissingMasterKey
+	}
+
+	var customerIds []string
+	err := p.db.Select(&customerIds, `select distinct customer_id from resources union select distinct customer_id from customer_keys`)
+	if err != nil {
+		return 0, err
+	}
+
+	var rotated int64
+	for _, customerId := range customerIds {
+		if _, err := p.keys.rotate(customerId); err != nil {
+			return rotated, err
+		}
+		rotated++
+	}
+
+	return rotated, nil
+}
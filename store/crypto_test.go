@@ -0,0 +1,75 @@
+package store
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+)
+
+func TestParseMasterKeys(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+
+	keys, err := ParseMasterKeys("new:" + key + ", old:" + key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 2 || keys[0].Id != "new" || keys[1].Id != "old" || len(keys[0].Key) != 32 {
+		t.Errorf("unexpected master keys: %#v", keys)
+	}
+
+	keys, err = ParseMasterKeys("")
+	if err != nil || len(keys) != 0 {
+		t.Errorf("expected no master keys, got %#v, %v", keys, err)
+	}
+
+	for _, invalid := range []string{
+		key,
+		"short:" + base64.StdEncoding.EncodeToString([]byte("too short")),
+		"bad:not base64",
+	} {
+		if _, err := ParseMasterKeys(invalid); err == nil {
+			t.Errorf("expected %q to be invalid", invalid)
+		}
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	aead, err := newAEAD(bytes.Repeat([]byte{2}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &resource{CustomerId: "customer", Region: "us-west-2", VpcId: "vpc-1", Id: "id"}
+	plaintext := []byte(`{"Vpcs":[]}`)
+
+	ciphertext, err := seal(aead, plaintext, r.aad("response"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(ciphertext, plaintext) {
+		t.Error("ciphertext contains the plaintext")
+	}
+
+	opened, err := open(aead, ciphertext, r.aad("response"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(opened, plaintext) {
+		t.Errorf("expected %s, got %s", plaintext, opened)
+	}
+
+	// moved to another column or customer, it shouldn't decrypt
+	other := &resource{CustomerId: "other", Region: "us-west-2", VpcId: "vpc-1", Id: "id"}
+	for _, aad := range [][]byte{r.aad("request"), other.aad("response")} {
+		if _, err := open(aead, ciphertext, aad); err == nil {
+			t.Errorf("expected ciphertext not to open with %s", aad)
+		}
+	}
+
+	if _, err := open(aead, []byte("short"), r.aad("response")); err != errInvalidCiphertext {
+		t.Errorf("expected invalid ciphertext, got %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+package store
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/gogo/protobuf/proto"
+)
+
+const (
+	// EncodingJSON stores responses as json in response_data.
+	EncodingJSON = "json"
+
+	// EncodingProto stores responses as gzipped protobuf in response_blob,
+	// which is smaller and much cheaper to decode.
+	EncodingProto = "proto+gzip"
+)
+
+func validEncoding(encoding string) bool {
+	return encoding == EncodingJSON || encoding == EncodingProto
+}
+
+// encodeOutput serializes an output for the response_data or response_blob
+// column, depending on the encoding. The other is returned nil.
+func encodeOutput(output interface{}, encoding string) ([]byte, []byte, error) {
+	switch encoding {
+	case EncodingJSON:
+		data, err := json.Marshal(output)
+		return data, nil, err
+
+	case EncodingProto:
+		msg, ok := output.(proto.Message)
+		if !ok {
+			return nil, nil, errInvalidAWSOutput
+		}
+
+		b, err := proto.Marshal(msg)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(b); err != nil {
+			return nil, nil, err
+		}
+
+		if err := w.Close(); err != nil {
+			return nil, nil, err
+		}
+
+		return nil, buf.Bytes(), nil
+	}
+
+	return nil, nil, errUnknownEncoding
+}
+
+// decodeOutput reads a resource's response into output, whichever encoding
+// it was saved in.
+func decodeOutput(resource *resource, output interface{}) error {
+	switch resource.Encoding {
+	case EncodingJSON, "":
+		return json.Unmarshal(resource.ResponseData, output)
+
+	case EncodingProto:
+		msg, ok := output.(proto.Message)
+		if !ok {
+			return errInvalidAWSOutput
+		}
+
+		r, err := gzip.NewReader(bytes.NewReader(resource.ResponseBlob))
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+
+		return proto.Unmarshal(b, msg)
+	}
+
+	return errUnknownEncoding
+}
@@ -0,0 +1,111 @@
+package store
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	opsee_aws_ec2 "github.com/opsee/basic/schema/aws/ec2"
+)
+
+// instancesOutput builds a DescribeInstancesOutput about the size of a busy
+// customer's.
+func instancesOutput(n int) *opsee_aws_ec2.DescribeInstancesOutput {
+	output := &opsee_aws_ec2.DescribeInstancesOutput{}
+
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("i-%08x", i)
+
+		output.Reservations = append(output.Reservations, &opsee_aws_ec2.Reservation{
+			ReservationId: aws.String(fmt.Sprintf("r-%08x", i)),
+			OwnerId:       aws.String("123456789012"),
+			Instances: []*opsee_aws_ec2.Instance{
+				{
+					InstanceId:       aws.String(id),
+					ImageId:          aws.String("ami-12345678"),
+					InstanceType:     aws.String("m4.large"),
+					PrivateDnsName:   aws.String("ip-10-0-0-1.us-west-2.compute.internal"),
+					PrivateIpAddress: aws.String("10.0.0.1"),
+					SubnetId:         aws.String("subnet-12345678"),
+					VpcId:            aws.String("vpc-12345678"),
+					SecurityGroups: []*opsee_aws_ec2.GroupIdentifier{
+						{GroupId: aws.String("sg-12345678"), GroupName: aws.String("default")},
+					},
+					Tags: []*opsee_aws_ec2.Tag{
+						{Key: aws.String("Name"), Value: aws.String(id)},
+					},
+				},
+			},
+		})
+	}
+
+	return output
+}
+
+func TestEncodeOutput(t *testing.T) {
+	for _, encoding := range []string{EncodingJSON, EncodingProto} {
+		output := instancesOutput(10)
+
+		data, blob, err := encodeOutput(output, encoding)
+		if err != nil {
+			t.Fatalf("%s: %s", encoding, err)
+		}
+
+		decoded := &opsee_aws_ec2.DescribeInstancesOutput{}
+		err = decodeOutput(&resource{Encoding: encoding, ResponseData: data, ResponseBlob: blob}, decoded)
+		if err != nil {
+			t.Fatalf("%s: %s", encoding, err)
+		}
+
+		if !reflect.DeepEqual(output, decoded) {
+			t.Errorf("%s: output changed in round trip", encoding)
+		}
+	}
+
+	if _, _, err := encodeOutput(instancesOutput(1), "xml"); err != errUnknownEncoding {
+		t.Errorf("expected unknown encoding, got %v", err)
+	}
+}
+
+func benchmarkEncode(b *testing.B, encoding string) {
+	output := instancesOutput(500)
+
+	data, blob, err := encodeOutput(output, encoding)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(data) + len(blob)))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := encodeOutput(output, encoding); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkDecode(b *testing.B, encoding string) {
+	data, blob, err := encodeOutput(instancesOutput(500), encoding)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(data) + len(blob)))
+
+	r := &resource{Encoding: encoding, ResponseData: data, ResponseBlob: blob}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := decodeOutput(r, &opsee_aws_ec2.DescribeInstancesOutput{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// The bytes reported by these benchmarks are the stored size of the output,
+// so MB/s isn't comparable between encodings, but the stored size is.
+
+func BenchmarkEncodeJSON(b *testing.B)  { benchmarkEncode(b, EncodingJSON) }
+func BenchmarkEncodeProto(b *testing.B) { benchmarkEncode(b, EncodingProto) }
+func BenchmarkDecodeJSON(b *testing.B)  { benchmarkDecode(b, EncodingJSON) }
+func BenchmarkDecodeProto(b *testing.B) { benchmarkDecode(b, EncodingProto) }
@@ -6,9 +6,28 @@ import (
 
 var (
 	errMissingCustomerId      = errors.New("missing customer id")
+	errMissingRegion          = errors.New("missing region")
+	errMissingVpcId           = errors.New("missing vpc id")
 	errMissingAWSRequest      = errors.New("missing AWS request struct")
 	errMissingAWSOutput       = errors.New("missing AWS resource output")
 	errMissingAWSRequestInput = errors.New("missing AWS request input")
+	errInvalidAWSRequestInput = errors.New("AWS request input is not a protobuf message")
 	errMissingUpdated         = errors.New("missing updated_at timestamp")
 	errResourceExpired        = errors.New("cached resource has expired")
+	errResourceNotFound       = errors.New("cached resource not found")
+	errResourceTooLarge       = errors.New("resource is too large to cache")
+	errJanitorUnsupported     = errors.New("janitor requires a postgres store")
+	errReencodeUnsupported    = errors.New("rewriting resources requires a postgres store")
+	errInvalidAWSOutput       = errors.New("AWS output is not a protobuf message")
+	errUnknownEncoding        = errors.New("unknown resource encoding")
+	errUnknownMasterKey       = errors.New("data key is wrapped by an unknown master key")
+	errMissingMasterKey       = errors.New("resource is encrypted, but no master key is configured")
+	errInvalidCiphertext      = errors.New("ciphertext is too short")
+	errLocksExhausted         = errors.New("every lock connection is in use")
 )
+
+// IsExpired returns true if Get failed because the resource it found is older
+// than the request allows.
+func IsExpired(err error) bool {
+	return err == errResourceExpired
+}
@@ -0,0 +1,215 @@
+package store
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	log "github.com/opsee/logrus"
+)
+
+var (
+	DefaultRetention        = 24 * time.Hour
+	DefaultJanitorInterval  = 10 * time.Minute
+	DefaultJanitorBatchSize = 1000
+)
+
+// janitorLock is the advisory lock key held by the replica running the
+// janitor.
+const janitorLock = "bezosphere/janitor"
+
+// JanitorConfig configures a Janitor. Zero values are replaced with the
+// defaults above.
+type JanitorConfig struct {
+	// Retention is how long after its last update a resource is deleted.
+	// It should be longer than any max age plus max staleness in use.
+	Retention time.Duration
+	Interval  time.Duration
+	BatchSize int
+}
+
+// JanitorStats counts the janitor's work since it started.
+type JanitorStats struct {
+	Runs        int64
+	Skipped     int64
+	RowsScanned int64
+	RowsDeleted int64
+}
+
+// Janitor periodically deletes resources that haven't been updated within
+// the retention period, in batches. Only one replica runs it at a time,
+// the others skip their turn while it holds an advisory lock.
+type Janitor struct {
+	db     *sqlx.DB
+	config JanitorConfig
+	stats  JanitorStats
+	stop   chan struct{}
+	once   sync.Once
+}
+
+// NewJanitor returns a janitor for a postgres backed store.
+func NewJanitor(s Store, config JanitorConfig) (*Janitor, error) {
+	p, ok := postgresStore(s)
+	if !ok {
+		return nil, errJanitorUnsupported
+	}
+
+	if config.Retention <= 0 {
+		config.Retention = DefaultRetention
+	}
+
+	if config.Interval <= 0 {
+		config.Interval = DefaultJanitorInterval
+	}
+
+	if config.BatchSize <= 0 {
+		config.BatchSize = DefaultJanitorBatchSize
+	}
+
+	return &Janitor{
+		db:     p.db,
+		config: config,
+		stop:   make(chan struct{}),
+	}, nil
+}
+
+// Start runs the janitor every interval until Stop is called.
+func (j *Janitor) Start() {
+	go func() {
+		ticker := time.NewTicker(j.config.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-j.stop:
+				return
+
+			case <-ticker.C:
+				if err := j.Run(); err != nil {
+					log.WithError(err).Error("error deleting expired resources")
+				}
+			}
+		}
+	}()
+}
+
+func (j *Janitor) Stop() {
+	j.once.Do(func() {
+		close(j.stop)
+	})
+}
+
+// Stats returns a snapshot of the janitor's counters.
+func (j *Janitor) Stats() JanitorStats {
+	return JanitorStats{
+		Runs:        atomic.LoadInt64(&j.stats.Runs),
+		Skipped:     atomic.LoadInt64(&j.stats.Skipped),
+		RowsScanned: atomic.LoadInt64(&j.stats.RowsScanned),
+		RowsDeleted: atomic.LoadInt64(&j.stats.RowsDeleted),
+	}
+}
+
+// Run deletes expired resources once, unless another replica is already
+// doing it.
+func (j *Janitor) Run() error {
+	locked, err := TryLocked(j.db, janitorLock, func() error {
+		atomic.AddInt64(&j.stats.Runs, 1)
+
+		cutoff := time.Now().UTC().Add(-1 * j.config.Retention)
+
+		scanned, deleted, err := DeleteInBatches(j.config.BatchSize, func() (int64, int64, error) {
+			scanned, deleted, err := j.deleteBatch(cutoff)
+			atomic.AddInt64(&j.stats.RowsScanned, scanned)
+			atomic.AddInt64(&j.stats.RowsDeleted, deleted)
+			return scanned, deleted, err
+		})
+		if err != nil {
+			return err
+		}
+
+		log.WithFields(log.Fields{
+			"rows_scanned": scanned,
+			"rows_deleted": deleted,
+		}).Info("deleted expired resources")
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if !locked {
+		atomic.AddInt64(&j.stats.Skipped, 1)
+		log.Debug("janitor running on another replica")
+	}
+
+	return nil
+}
+
+// TryLocked runs fn while holding the advisory lock for key, so only one
+// replica runs it at a time. It returns false without running fn if another
+// replica holds the lock. The lock is held by a transaction that stays open
+// until fn returns, so fn's own queries run and commit on their own.
+func TryLocked(db *sqlx.DB, key string, fn func() error) (bool, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Commit()
+
+	var locked bool
+	err = tx.Get(&locked, `select pg_try_advisory_xact_lock($1)`, lockId(key))
+	if err != nil || !locked {
+		return false, err
+	}
+
+	return true, fn()
+}
+
+// DeleteInBatches calls deleteBatch until a batch scans fewer than batchSize
+// rows, returning how many rows were scanned and deleted in all.
+func DeleteInBatches(batchSize int, deleteBatch func() (int64, int64, error)) (int64, int64, error) {
+	var totalScanned, totalDeleted int64
+
+	for {
+		scanned, deleted, err := deleteBatch()
+		totalScanned += scanned
+		totalDeleted += deleted
+		if err != nil {
+			return totalScanned, totalDeleted, err
+		}
+
+		if scanned < int64(batchSize) {
+			return totalScanned, totalDeleted, nil
+		}
+	}
+}
+
+// deleteBatch deletes up to a batch of resources last updated before cutoff,
+// oldest first. Rows that were updated between being found and deleted are
+// scanned but kept.
+func (j *Janitor) deleteBatch(cutoff time.Time) (int64, int64, error) {
+	var counts struct {
+		Scanned int64 `db:"scanned"`
+		Deleted int64 `db:"deleted"`
+	}
+
+	err := j.db.Get(
+		&counts,
+		`with expired as (
+		   select customer_id, region, vpc_id, id from resources
+		   where updated_at < $1 order by updated_at limit $2
+		 ), deleted as (
+		   delete from resources r using expired e
+		   where r.customer_id = e.customer_id and r.region = e.region and r.vpc_id = e.vpc_id and r.id = e.id
+		   and r.updated_at < $1
+		   returning 1
+		 )
+		 select (select count(*) from expired) as scanned, (select count(*) from deleted) as deleted`,
+		cutoff,
+		j.config.BatchSize,
+	)
+
+	return counts.Scanned, counts.Deleted, err
+}
@@ -0,0 +1,45 @@
+package store
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDeleteInBatches(t *testing.T) {
+	batches := [][2]int64{{10, 10}, {10, 7}, {3, 3}, {10, 10}}
+
+	calls := 0
+	scanned, deleted, err := DeleteInBatches(10, func() (int64, int64, error) {
+		batch := batches[calls]
+		calls++
+		return batch[0], batch[1], nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if calls != 3 {
+		t.Errorf("expected to stop after a short batch, got %d batches", calls)
+	}
+
+	if scanned != 23 || deleted != 20 {
+		t.Errorf("expected 23 scanned and 20 deleted, got %d and %d", scanned, deleted)
+	}
+}
+
+func TestDeleteInBatchesError(t *testing.T) {
+	failed := errors.New("failed")
+
+	calls := 0
+	scanned, _, err := DeleteInBatches(10, func() (int64, int64, error) {
+		calls++
+		if calls == 2 {
+			return 0, 0, failed
+		}
+		return 10, 10, nil
+	})
+
+	if err != failed || calls != 2 || scanned != 10 {
+		t.Errorf("expected to stop at the error after 10 rows, got %v after %d batches and %d rows", err, calls, scanned)
+	}
+}
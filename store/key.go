@@ -0,0 +1,158 @@
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"sort"
+
+	"github.com/gogo/protobuf/proto"
+)
+
+// cacheKey derives the resource id for an AWS request input. The input is
+// cloned and normalized before hashing so that equivalent requests share a
+// key: repeated fields are sorted, empty slices and zero-valued scalars are
+// dropped, and the result is marshaled as protobuf. The type name is hashed
+// along with the bytes so that two empty inputs of different types don't
+// collide, as is whether the output holds every page.
+func cacheKey(input interface{}, allPages bool) (string, error) {
+	msg, ok := input.(proto.Message)
+	if !ok {
+		return "", errInvalidAWSRequestInput
+	}
+
+	canonical, err := canonicalize(msg)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	hash.Write([]byte(reflect.TypeOf(input).String()))
+	hash.Write([]byte{0})
+	hash.Write(canonical)
+
+	if allPages {
+		hash.Write([]byte("\x00all-pages"))
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func canonicalize(msg proto.Message) ([]byte, error) {
+	clone := proto.Clone(msg)
+
+	if err := normalize(reflect.ValueOf(clone)); err != nil {
+		return nil, err
+	}
+
+	return proto.Marshal(clone)
+}
+
+// normalize walks a message in place, clearing empty fields and sorting
+// repeated ones. Nested messages are normalized before their parent slice is
+// sorted, so ordering is by canonical bytes.
+func normalize(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+
+		elem := v.Elem()
+		if elem.Kind() != reflect.Struct {
+			if isZero(elem) && v.CanSet() {
+				v.Set(reflect.Zero(v.Type()))
+			}
+			return nil
+		}
+
+		return normalize(elem)
+
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Field(i)
+			if !field.CanSet() {
+				continue
+			}
+
+			if v.Type().Field(i).Name == "XXX_unrecognized" {
+				field.Set(reflect.Zero(field.Type()))
+				continue
+			}
+
+			if err := normalize(field); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Slice:
+		if v.Len() == 0 {
+			if v.CanSet() {
+				v.Set(reflect.Zero(v.Type()))
+			}
+			return nil
+		}
+
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return nil
+		}
+
+		return sortSlice(v)
+	}
+
+	return nil
+}
+
+func sortSlice(v reflect.Value) error {
+	keys := make([][]byte, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		elem := v.Index(i)
+		if err := normalize(elem); err != nil {
+			return err
+		}
+
+		switch e := elem.Interface().(type) {
+		case proto.Message:
+			b, err := proto.Marshal(e)
+			if err != nil {
+				return err
+			}
+			keys[i] = b
+		case string:
+			keys[i] = []byte(e)
+		default:
+			// non-string scalars have no ordering we care about
+			return nil
+		}
+	}
+
+	sort.Sort(&sortable{v: v, keys: keys})
+	return nil
+}
+
+func isZero(v reflect.Value) bool {
+	return v.Interface() == reflect.Zero(v.Type()).Interface()
+}
+
+type sortable struct {
+	v    reflect.Value
+	keys [][]byte
+}
+
+func (s *sortable) Len() int {
+	return len(s.keys)
+}
+
+func (s *sortable) Less(i, j int) bool {
+	return bytes.Compare(s.keys[i], s.keys[j]) < 0
+}
+
+func (s *sortable) Swap(i, j int) {
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+
+	tmp := reflect.New(s.v.Type().Elem()).Elem()
+	tmp.Set(s.v.Index(i))
+	s.v.Index(i).Set(s.v.Index(j))
+	s.v.Index(j).Set(tmp)
+}
@@ -0,0 +1,171 @@
+package store
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	opsee_aws_cloudwatch "github.com/opsee/basic/schema/aws/cloudwatch"
+	opsee_aws_ec2 "github.com/opsee/basic/schema/aws/ec2"
+	opsee_aws_ecs "github.com/opsee/basic/schema/aws/ecs"
+)
+
+func TestCacheKey(t *testing.T) {
+	tests := []struct {
+		name  string
+		a     interface{}
+		b     interface{}
+		equal bool
+	}{
+		{
+			name:  "identical inputs",
+			a:     &opsee_aws_ec2.DescribeInstancesInput{InstanceIds: []string{"i-1"}},
+			b:     &opsee_aws_ec2.DescribeInstancesInput{InstanceIds: []string{"i-1"}},
+			equal: true,
+		},
+		{
+			name:  "instance ids in different order",
+			a:     &opsee_aws_ec2.DescribeInstancesInput{InstanceIds: []string{"i-1", "i-2"}},
+			b:     &opsee_aws_ec2.DescribeInstancesInput{InstanceIds: []string{"i-2", "i-1"}},
+			equal: true,
+		},
+		{
+			name: "filters in different order",
+			a: &opsee_aws_ec2.DescribeInstancesInput{Filters: []*opsee_aws_ec2.Filter{
+				{Name: aws.String("vpc-id"), Values: []string{"vpc-1"}},
+				{Name: aws.String("instance-state-name"), Values: []string{"running", "stopped"}},
+			}},
+			b: &opsee_aws_ec2.DescribeInstancesInput{Filters: []*opsee_aws_ec2.Filter{
+				{Name: aws.String("instance-state-name"), Values: []string{"stopped", "running"}},
+				{Name: aws.String("vpc-id"), Values: []string{"vpc-1"}},
+			}},
+			equal: true,
+		},
+		{
+			name:  "nil and empty slices",
+			a:     &opsee_aws_ec2.DescribeInstancesInput{},
+			b:     &opsee_aws_ec2.DescribeInstancesInput{InstanceIds: []string{}, Filters: []*opsee_aws_ec2.Filter{}},
+			equal: true,
+		},
+		{
+			name:  "empty scalar fields",
+			a:     &opsee_aws_ecs.ListTasksInput{Cluster: aws.String("prod")},
+			b:     &opsee_aws_ecs.ListTasksInput{Cluster: aws.String("prod"), NextToken: aws.String(""), MaxResults: aws.Int64(0)},
+			equal: true,
+		},
+		{
+			name:  "different instance ids",
+			a:     &opsee_aws_ec2.DescribeInstancesInput{InstanceIds: []string{"i-1"}},
+			b:     &opsee_aws_ec2.DescribeInstancesInput{InstanceIds: []string{"i-2"}},
+			equal: false,
+		},
+		{
+			name: "filter values moved between filters",
+			a: &opsee_aws_ec2.DescribeInstancesInput{Filters: []*opsee_aws_ec2.Filter{
+				{Name: aws.String("tag:a"), Values: []string{"1", "2"}},
+				{Name: aws.String("tag:b"), Values: []string{"3"}},
+			}},
+			b: &opsee_aws_ec2.DescribeInstancesInput{Filters: []*opsee_aws_ec2.Filter{
+				{Name: aws.String("tag:a"), Values: []string{"1"}},
+				{Name: aws.String("tag:b"), Values: []string{"2", "3"}},
+			}},
+			equal: false,
+		},
+		{
+			name:  "same fields on different input types",
+			a:     &opsee_aws_ec2.DescribeSubnetsInput{},
+			b:     &opsee_aws_ec2.DescribeVpcsInput{},
+			equal: false,
+		},
+		{
+			name:  "same cluster on different ecs calls",
+			a:     &opsee_aws_ecs.ListServicesInput{Cluster: aws.String("prod")},
+			b:     &opsee_aws_ecs.ListContainerInstancesInput{Cluster: aws.String("prod")},
+			equal: false,
+		},
+		{
+			name:  "different metric namespace",
+			a:     &opsee_aws_cloudwatch.ListMetricsInput{Namespace: aws.String("AWS/EC2")},
+			b:     &opsee_aws_cloudwatch.ListMetricsInput{Namespace: aws.String("AWS/ELB")},
+			equal: false,
+		},
+	}
+
+	for _, test := range tests {
+		a, err := cacheKey(test.a, false)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+
+		b, err := cacheKey(test.b, false)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+
+		if (a == b) != test.equal {
+			t.Errorf("%s: expected equal=%t, got %s and %s", test.name, test.equal, a, b)
+		}
+
+		if len(a) != 64 {
+			t.Errorf("%s: expected a 64 character key, got %d", test.name, len(a))
+		}
+	}
+}
+
+func TestCacheKeyAllPages(t *testing.T) {
+	input := &opsee_aws_ec2.DescribeInstancesInput{InstanceIds: []string{"i-1"}}
+
+	single, err := cacheKey(input, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := cacheKey(input, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if single == all {
+		t.Error("expected all pages to be keyed separately from a single page")
+	}
+}
+
+func TestCacheKeyDoesNotModifyInput(t *testing.T) {
+	input := &opsee_aws_ec2.DescribeInstancesInput{
+		InstanceIds: []string{"i-2", "i-1"},
+		NextToken:   aws.String(""),
+	}
+
+	if _, err := cacheKey(input, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if input.InstanceIds[0] != "i-2" || input.NextToken == nil {
+		t.Errorf("input was modified: %#v", input)
+	}
+}
+
+func TestRequestKeyScope(t *testing.T) {
+	keys := make(map[string]string)
+
+	for _, scope := range [][3]string{
+		{"customer", "us-west-2", "vpc-1"},
+		{"customer", "us-west-2", "vpc-2"},
+		{"customer", "us-east-1", "vpc-1"},
+		{"other", "us-west-2", "vpc-1"},
+	} {
+		req := vpcRequest("vpc-1")
+		req.CustomerId, req.Region, req.VpcId = scope[0], scope[1], scope[2]
+
+		key, err := req.Key()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		name := strings.Join(scope[:], "/")
+		if other, ok := keys[key]; ok {
+			t.Errorf("expected %s and %s to have different keys", name, other)
+		}
+		keys[key] = name
+	}
+}
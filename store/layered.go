@@ -0,0 +1,113 @@
+package store
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+	log "github.com/opsee/logrus"
+	"golang.org/x/net/context"
+)
+
+const (
+	// resourcesChannel is notified with a resource's key whenever its row
+	// changes, see migrations/0005_notify_resources.up.sql.
+	resourcesChannel = "resources"
+
+	minListenerReconnect = 10 * time.Second
+	maxListenerReconnect = time.Minute
+)
+
+type layered struct {
+	local    *memory
+	remote   *postgres
+	listener *pq.Listener
+}
+
+// NewLayered returns a Store that keeps up to maxBytes of resources in
+// process in front of postgres. Puts only go to postgres, and a miss in
+// memory is filled from there. Replicas drop their in-memory copy when
+// postgres notifies them that a resource has changed, and everything they
+// have if they lose the notification connection, since they may have missed
+// some.
+func NewLayered(config PostgresConfig, maxBytes int64) (Store, error) {
+	remote, err := NewPostgres(config)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &layered{
+		local:  newMemory(maxBytes, config.Policy),
+		remote: remote.(*postgres),
+	}
+
+	s.listener = pq.NewListener(config.Connection, minListenerReconnect, maxListenerReconnect, s.listenerEvent)
+	if err := s.listener.Listen(resourcesChannel); err != nil {
+		return nil, err
+	}
+
+	go s.invalidate()
+
+	return s, nil
+}
+
+// Put writes to postgres only. Writing to memory too would be wasted, since
+// postgres notifies this replica of its own writes as well, and that would
+// drop the entry again as soon as it arrived. The next Get fills it instead.
+func (s *layered) Put(req Request) error {
+	return s.remote.Put(req)
+}
+
+func (s *layered) Get(req Request) (*Metadata, error) {
+	meta, err := s.local.Get(req)
+	if err == nil {
+		return meta, nil
+	}
+
+	generation := s.local.currentGeneration()
+
+	meta, err = s.remote.Get(req)
+	if err != nil {
+		return nil, err
+	}
+
+	req.AWSRequestId = meta.AWSRequestId
+	req.Error = meta.Error
+	if err := s.local.putIfUnchanged(req, meta.UpdatedAt, generation); err != nil {
+		log.WithError(err).Warn("couldn't cache resource in memory")
+	}
+
+	return meta, nil
+}
+
+// Lock coordinates replicas through postgres, see postgres.Lock.
+func (s *layered) Lock(ctx context.Context, key string) (func() error, error) {
+	return s.remote.Lock(ctx, key)
+}
+
+// invalidate drops resources from memory as postgres tells us they change.
+func (s *layered) invalidate() {
+	for n := range s.listener.Notify {
+		// a nil notification means the connection was re-established, and we
+		// may have missed something in between
+		if n == nil {
+			s.local.purge()
+			continue
+		}
+
+		s.local.invalidate(n.Extra)
+	}
+}
+
+func (s *layered) listenerEvent(event pq.ListenerEventType, err error) {
+	switch event {
+	case pq.ListenerEventDisconnected:
+		log.WithError(err).Warn("lost resource notification connection")
+		s.local.purge()
+
+	case pq.ListenerEventConnectionAttemptFailed:
+		log.WithError(err).Error("couldn't connect for resource notifications")
+
+	case pq.ListenerEventReconnected:
+		log.Info("reconnected for resource notifications")
+	}
+}
@@ -0,0 +1,217 @@
+package store
+
+import (
+	"container/list"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	opsee_types "github.com/opsee/protobuf/opseeproto/types"
+)
+
+var (
+	DefaultMemoryMaxBytes int64 = 64 << 20
+)
+
+type memory struct {
+	sync.Mutex
+	policy     *Policy
+	maxBytes   int64
+	bytes      int64
+	lru        *list.List
+	items      map[string]*list.Element
+	generation uint64
+}
+
+type memoryItem struct {
+	key      string
+	resource *resource
+	size     int64
+
+	// output is a decoded copy of the resource's response, so hits don't
+	// have to unmarshal it again
+	output proto.Message
+}
+
+// NewMemory returns a Store that keeps resources in process, evicting the
+// least recently used ones once they take up more than maxBytes, as measured
+// by their serialized size. Requests without a MaxAge get one from policy, or
+// DefaultPolicy if it's nil.
+func NewMemory(maxBytes int64, policy *Policy) Store {
+	return newMemory(maxBytes, policy)
+}
+
+func newMemory(maxBytes int64, policy *Policy) *memory {
+	if maxBytes <= 0 {
+		maxBytes = DefaultMemoryMaxBytes
+	}
+
+	if policy == nil {
+		policy = DefaultPolicy()
+	}
+
+	return &memory{
+		policy:   policy,
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (s *memory) Put(req Request) error {
+	now := &opsee_types.Timestamp{}
+	if err := now.Scan(time.Now().UTC()); err != nil {
+		return err
+	}
+
+	item, err := s.newItem(req, now)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.insert(item)
+	return nil
+}
+
+func (s *memory) Get(req Request) (*Metadata, error) {
+	key, err := req.Key()
+	if err != nil {
+		return nil, err
+	}
+
+	s.Lock()
+	elem, ok := s.items[key]
+	if ok {
+		s.lru.MoveToFront(elem)
+	}
+	s.Unlock()
+
+	if !ok {
+		return nil, errResourceNotFound
+	}
+
+	// items are replaced on put rather than modified, so it's safe to read
+	// this one without the lock
+	item := elem.Value.(*memoryItem)
+
+	if item.output == nil || reflect.TypeOf(item.output) != reflect.TypeOf(req.Output) {
+		return req.hydrate(item.resource, s.policy)
+	}
+
+	meta, err := req.metadata(item.resource, s.policy)
+	if err != nil {
+		return nil, err
+	}
+
+	reflect.ValueOf(req.Output).Elem().Set(reflect.ValueOf(proto.Clone(item.output)).Elem())
+	return meta, nil
+}
+
+// putIfUnchanged caches a resource that was last updated at updatedAt, as
+// long as nothing has been invalidated since generation. Use it to fill the
+// cache from another store without racing an invalidation.
+func (s *memory) putIfUnchanged(req Request, updatedAt *opsee_types.Timestamp, generation uint64) error {
+	item, err := s.newItem(req, updatedAt)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if s.generation == generation {
+		s.insert(item)
+	}
+
+	return nil
+}
+
+// currentGeneration returns a counter that changes with every invalidation.
+func (s *memory) currentGeneration() uint64 {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.generation
+}
+
+// invalidate drops the resource with a key, if we have it.
+func (s *memory) invalidate(key string) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.generation++
+
+	if elem, ok := s.items[key]; ok {
+		s.remove(elem)
+	}
+}
+
+// purge drops every resource.
+func (s *memory) purge() {
+	s.Lock()
+	defer s.Unlock()
+
+	s.generation++
+	s.lru.Init()
+	s.items = make(map[string]*list.Element)
+	s.bytes = 0
+}
+
+func (s *memory) newItem(req Request, updatedAt *opsee_types.Timestamp) (*memoryItem, error) {
+	key, err := req.Key()
+	if err != nil {
+		return nil, err
+	}
+
+	resource, err := req.resource(EncodingJSON)
+	if err != nil {
+		return nil, err
+	}
+	resource.CreatedAt = updatedAt
+	resource.UpdatedAt = updatedAt
+
+	size := int64(len(key) + len(resource.RequestData) + len(resource.ResponseData))
+	if size > s.maxBytes {
+		return nil, errResourceTooLarge
+	}
+
+	item := &memoryItem{
+		key:      key,
+		resource: resource,
+		size:     size,
+	}
+
+	if output, ok := req.Output.(proto.Message); ok && req.Error == nil {
+		item.output = proto.Clone(output)
+	}
+
+	return item, nil
+}
+
+// insert caches an item, evicting the least recently used ones to make room.
+// It must be called with the store locked.
+func (s *memory) insert(item *memoryItem) {
+	if elem, ok := s.items[item.key]; ok {
+		item.resource.CreatedAt = elem.Value.(*memoryItem).resource.CreatedAt
+		s.remove(elem)
+	}
+
+	s.items[item.key] = s.lru.PushFront(item)
+	s.bytes += item.size
+
+	for s.bytes > s.maxBytes {
+		s.remove(s.lru.Back())
+	}
+}
+
+// remove drops an item from the cache. It must be called with the store
+// locked.
+func (s *memory) remove(elem *list.Element) {
+	item := s.lru.Remove(elem).(*memoryItem)
+	delete(s.items, item.key)
+	s.bytes -= item.size
+}
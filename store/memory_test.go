@@ -0,0 +1,286 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	opsee_aws_ec2 "github.com/opsee/basic/schema/aws/ec2"
+	opsee_types "github.com/opsee/protobuf/opseeproto/types"
+)
+
+func vpcRequest(vpcId string) Request {
+	return Request{
+		CustomerId: "customer",
+		Region:     "us-west-2",
+		VpcId:      "vpc-1",
+		Input:      &opsee_aws_ec2.DescribeVpcsInput{VpcIds: []string{vpcId}},
+		Output: &opsee_aws_ec2.DescribeVpcsOutput{Vpcs: []*opsee_aws_ec2.Vpc{
+			{VpcId: aws.String(vpcId), CidrBlock: aws.String("10.0.0.0/16")},
+		}},
+	}
+}
+
+func timestamp(t *testing.T, tm time.Time) *opsee_types.Timestamp {
+	ts := &opsee_types.Timestamp{}
+	if err := ts.Scan(tm.UTC()); err != nil {
+		t.Fatal(err)
+	}
+	return ts
+}
+
+func TestMemoryGetPut(t *testing.T) {
+	s := NewMemory(0, nil)
+
+	req := vpcRequest("vpc-1")
+	if _, err := s.Get(req); err != errResourceNotFound {
+		t.Fatalf("expected not found, got %v", err)
+	}
+
+	req.AWSRequestId = "request-1"
+	if err := s.Put(req); err != nil {
+		t.Fatal(err)
+	}
+
+	get := vpcRequest("vpc-1")
+	get.Output = &opsee_aws_ec2.DescribeVpcsOutput{}
+
+	meta, err := s.Get(get)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if meta.AWSRequestId != "request-1" || meta.Stale {
+		t.Errorf("unexpected metadata: %#v", meta)
+	}
+
+	output := get.Output.(*opsee_aws_ec2.DescribeVpcsOutput)
+	if len(output.Vpcs) != 1 || aws.StringValue(output.Vpcs[0].VpcId) != "vpc-1" {
+		t.Errorf("unexpected output: %#v", output)
+	}
+}
+
+func TestMemoryMaxAge(t *testing.T) {
+	s := NewMemory(0, nil)
+
+	if err := s.Put(vpcRequest("vpc-1")); err != nil {
+		t.Fatal(err)
+	}
+
+	req := vpcRequest("vpc-1")
+	req.MaxAge = timestamp(t, time.Now().Add(time.Minute))
+
+	if _, err := s.Get(req); err != errResourceExpired {
+		t.Fatalf("expected expired, got %v", err)
+	}
+
+	req.MaxStale = 2 * time.Minute
+
+	meta, err := s.Get(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !meta.Stale {
+		t.Error("expected a stale resource")
+	}
+}
+
+func TestMemoryEviction(t *testing.T) {
+	first, second, third := vpcRequest("vpc-1"), vpcRequest("vpc-2"), vpcRequest("vpc-3")
+
+	key, err := first.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resource, err := first.resource(EncodingJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// room for two resources, not three
+	size := int64(len(key) + len(resource.RequestData) + len(resource.ResponseData))
+	s := NewMemory(size*2+size/2, nil)
+
+	for _, req := range []Request{first, second} {
+		if err := s.Put(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// touch the first so the second is least recently used
+	if _, err := s.Get(first); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Put(third); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Get(second); err != errResourceNotFound {
+		t.Errorf("expected the second resource to be evicted, got %v", err)
+	}
+
+	for _, req := range []Request{first, third} {
+		if _, err := s.Get(req); err != nil {
+			t.Errorf("expected %v to be cached, got %v", req.Input, err)
+		}
+	}
+
+	if err := s.Put(vpcRequest("vpc-1")); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := s.(*memory).bytes; n > size*2+size/2 {
+		t.Errorf("store holds %d bytes, more than its max", n)
+	}
+}
+
+func TestMemoryTooLarge(t *testing.T) {
+	s := NewMemory(16, nil)
+
+	if err := s.Put(vpcRequest("vpc-1")); err != errResourceTooLarge {
+		t.Errorf("expected too large, got %v", err)
+	}
+}
+
+func TestMemoryPutIfUnchanged(t *testing.T) {
+	s := newMemory(0, nil)
+	updated := timestamp(t, time.Now())
+
+	generation := s.currentGeneration()
+	s.invalidate("customer/us-west-2/vpc-1/something-else")
+
+	if err := s.putIfUnchanged(vpcRequest("vpc-1"), updated, generation); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Get(vpcRequest("vpc-1")); err != errResourceNotFound {
+		t.Fatalf("expected a fill racing an invalidation to be dropped, got %v", err)
+	}
+
+	if err := s.putIfUnchanged(vpcRequest("vpc-1"), updated, s.currentGeneration()); err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := s.Get(vpcRequest("vpc-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if meta.UpdatedAt.Millis() != updated.Millis() {
+		t.Errorf("expected updated at %d, got %d", updated.Millis(), meta.UpdatedAt.Millis())
+	}
+
+	key, err := vpcRequest("vpc-1").Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.invalidate(key)
+
+	if _, err := s.Get(vpcRequest("vpc-1")); err != errResourceNotFound {
+		t.Errorf("expected invalidated resource to be gone, got %v", err)
+	}
+}
+
+func TestMemoryError(t *testing.T) {
+	s := NewMemory(0, nil)
+
+	req := vpcRequest("vpc-1")
+	req.AWSRequestId = "request-1"
+	req.Error = &Error{
+		Code:       "InvalidVpcID.NotFound",
+		Message:    "The vpc ID 'vpc-1' does not exist",
+		StatusCode: 400,
+		TTL:        time.Minute,
+	}
+
+	if err := s.Put(req); err != nil {
+		t.Fatal(err)
+	}
+
+	get := vpcRequest("vpc-1")
+	get.Output = &opsee_aws_ec2.DescribeVpcsOutput{}
+
+	meta, err := s.Get(get)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if meta.Error == nil || *meta.Error != *req.Error || meta.AWSRequestId != "request-1" {
+		t.Errorf("unexpected metadata: %#v", meta)
+	}
+
+	if output := get.Output.(*opsee_aws_ec2.DescribeVpcsOutput); len(output.Vpcs) != 0 {
+		t.Errorf("expected output to be left alone, got %#v", output)
+	}
+
+	get.MaxAge = timestamp(t, time.Now().Add(time.Second))
+	get.MaxStale = time.Hour
+
+	if _, err := s.Get(get); err != errResourceExpired {
+		t.Errorf("expected errors newer than max age only, got %v", err)
+	}
+
+	req.Error.TTL = -time.Minute
+	if err := s.Put(req); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Get(vpcRequest("vpc-1")); err != errResourceExpired {
+		t.Errorf("expected error past its ttl to be expired, got %v", err)
+	}
+
+	if err := s.Put(vpcRequest("vpc-1")); err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err = s.Get(vpcRequest("vpc-1"))
+	if err != nil || meta.Error != nil {
+		t.Errorf("expected an output to replace the error, got %#v, %v", meta, err)
+	}
+}
+
+func TestMemoryScope(t *testing.T) {
+	s := NewMemory(0, nil)
+
+	scoped := func(region, vpcId string) Request {
+		req := vpcRequest("vpc-1")
+		req.Region, req.VpcId = region, vpcId
+		req.AWSRequestId = region + "/" + vpcId
+		return req
+	}
+
+	puts := []Request{
+		scoped("us-west-2", "vpc-1"),
+		scoped("us-west-2", "vpc-2"),
+		scoped("us-east-1", "vpc-1"),
+	}
+
+	for _, req := range puts {
+		if err := s.Put(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, req := range puts {
+		get := req
+		get.Output = &opsee_aws_ec2.DescribeVpcsOutput{}
+
+		meta, err := s.Get(get)
+		if err != nil {
+			t.Errorf("%s: %v", req.AWSRequestId, err)
+			continue
+		}
+
+		if meta.AWSRequestId != req.AWSRequestId {
+			t.Errorf("%s: expected its own resource, got %s's", req.AWSRequestId, meta.AWSRequestId)
+		}
+	}
+
+	if _, err := s.Get(scoped("us-east-1", "vpc-2")); err != errResourceNotFound {
+		t.Errorf("expected a vpc nothing was put in to miss, got %v", err)
+	}
+}
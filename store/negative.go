@@ -0,0 +1,50 @@
+package store
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// errorResponseType is the response_type of a resource holding an AWS error
+// rather than an output. Its response is the Error, as json.
+const errorResponseType = "error"
+
+// Error is an AWS error cached in place of a request's output, so requests
+// that will fail the same way every time don't keep going to AWS. It's only
+// returned by Get until TTL has passed, it's never stale.
+type Error struct {
+	Code       string        `json:"code"`
+	Message    string        `json:"message"`
+	StatusCode int           `json:"status_code"`
+	TTL        time.Duration `json:"ttl"`
+}
+
+// errorMetadata checks a cached error hasn't expired, and describes it. An
+// error is fresh for its TTL, unless the request's MaxAge asks for something
+// newer.
+func (req Request) errorMetadata(resource *resource) (*Metadata, error) {
+	if resource.UpdatedAt == nil {
+		return nil, errMissingUpdated
+	}
+
+	cachedErr := &Error{}
+	if err := json.Unmarshal(resource.ResponseData, cachedErr); err != nil {
+		return nil, err
+	}
+
+	updatedAt := resource.UpdatedAt.Millis()
+
+	if updatedAt+int64(cachedErr.TTL/time.Millisecond) < time.Now().UTC().UnixNano()/1e6 {
+		return nil, errResourceExpired
+	}
+
+	if req.MaxAge != nil && updatedAt < req.MaxAge.Millis() {
+		return nil, errResourceExpired
+	}
+
+	return &Metadata{
+		UpdatedAt:    resource.UpdatedAt,
+		AWSRequestId: resource.AWSRequestId,
+		Error:        cachedErr,
+	}, nil
+}
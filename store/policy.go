@@ -0,0 +1,187 @@
+package store
+
+import (
+	"fmt"
+	"path"
+	"reflect"
+	"strings"
+	"time"
+
+	opsee_types "github.com/opsee/protobuf/opseeproto/types"
+)
+
+// TTL is the caching policy for a type of request. MaxAge is used when the
+// caller doesn't send one, MaxStale is how much older than that a resource can
+// still be served while it's refreshed.
+type TTL struct {
+	MaxAge   time.Duration
+	MaxStale time.Duration
+	Disabled bool
+}
+
+// Policy holds a TTL per request type, keyed like "ec2.DescribeVpcs", and a
+// default for everything else.
+type Policy struct {
+	Default TTL
+	Types   map[string]TTL
+}
+
+// DefaultPolicy caches everything for DefaultTTL, except metric statistics
+// which are only useful fresh.
+func DefaultPolicy() *Policy {
+	return &Policy{
+		Default: TTL{MaxAge: DefaultTTL},
+		Types: map[string]TTL{
+			"cloudwatch.GetMetricStatistics": {Disabled: true},
+		},
+	}
+}
+
+// ParsePolicy reads a comma separated list of type=ttl entries on top of the
+// default policy. A ttl is either a max age, a max age and max staleness
+// separated by a slash, or "off" to disable caching, e.g.
+//
+//	default=2m,ec2.DescribeVpcs=1h/6h,ecs.ListTasks=15s,cloudwatch.GetMetricStatistics=off
+//
+// Inputs are empty values of every input that may be requested, types that
+// don't name one of them are rejected.
+func ParsePolicy(s string, inputs []interface{}) (*Policy, error) {
+	policy := DefaultPolicy()
+
+	known := make(map[string]bool)
+	for _, input := range inputs {
+		known[RequestType(input)] = true
+	}
+
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid ttl policy entry: %s", entry)
+		}
+
+		ttl, err := parseTTL(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid ttl policy entry: %s: %s", entry, err)
+		}
+
+		switch {
+		case parts[0] == "default":
+			policy.Default = ttl
+
+		case known[parts[0]]:
+			policy.Types[parts[0]] = ttl
+
+		default:
+			return nil, fmt.Errorf("invalid ttl policy entry: %s: unknown request type %s", entry, parts[0])
+		}
+	}
+
+	return policy, nil
+}
+
+func parseTTL(s string) (TTL, error) {
+	if s == "off" {
+		return TTL{Disabled: true}, nil
+	}
+
+	var (
+		ttl   TTL
+		err   error
+		parts = strings.SplitN(s, "/", 2)
+	)
+
+	ttl.MaxAge, err = time.ParseDuration(parts[0])
+	if err != nil {
+		return ttl, err
+	}
+
+	if len(parts) == 2 {
+		ttl.MaxStale, err = time.ParseDuration(parts[1])
+		if err != nil {
+			return ttl, err
+		}
+	}
+
+	return ttl, nil
+}
+
+// For returns the TTL for an AWS request input.
+func (p *Policy) For(input interface{}) TTL {
+	if ttl, ok := p.Types[RequestType(input)]; ok {
+		return ttl
+	}
+
+	return p.Default
+}
+
+// MaxAge returns the oldest an input's resource can be to be served as fresh.
+func (p *Policy) MaxAge(input interface{}) (*opsee_types.Timestamp, error) {
+	maxAge := &opsee_types.Timestamp{}
+	err := maxAge.Scan(time.Now().UTC().Add(-1 * p.For(input).MaxAge))
+	if err != nil {
+		return nil, err
+	}
+
+	return maxAge, nil
+}
+
+// Apply fills in a request's MaxAge and MaxStale from the policy, if the
+// caller didn't send a max age. Callers that did want nothing older, so they
+// get no staleness.
+func (p *Policy) Apply(req Request) (Request, error) {
+	if req.MaxAge != nil {
+		req.MaxStale = 0
+		return req, nil
+	}
+
+	maxAge, err := p.MaxAge(req.Input)
+	if err != nil {
+		return req, err
+	}
+
+	req.MaxAge = maxAge
+	req.MaxStale = p.For(req.Input).MaxStale
+	return req, nil
+}
+
+// WithMaxStale returns a copy of the policy where every TTL without a max
+// staleness has maxStale.
+func (p *Policy) WithMaxStale(maxStale time.Duration) *Policy {
+	policy := &Policy{
+		Default: p.Default,
+		Types:   make(map[string]TTL, len(p.Types)),
+	}
+
+	if policy.Default.MaxStale == 0 {
+		policy.Default.MaxStale = maxStale
+	}
+
+	for name, ttl := range p.Types {
+		if ttl.MaxStale == 0 {
+			ttl.MaxStale = maxStale
+		}
+		policy.Types[name] = ttl
+	}
+
+	return policy
+}
+
+// RequestType names an AWS request input by service and operation, e.g.
+// *opsee_aws_ec2.DescribeVpcsInput is "ec2.DescribeVpcs".
+func RequestType(input interface{}) string {
+	t := reflect.TypeOf(input)
+	if t == nil {
+		return ""
+	}
+
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	return path.Base(t.PkgPath()) + "." + strings.TrimSuffix(t.Name(), "Input")
+}
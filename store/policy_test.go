@@ -0,0 +1,80 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	opsee_aws_cloudwatch "github.com/opsee/basic/schema/aws/cloudwatch"
+	opsee_aws_ec2 "github.com/opsee/basic/schema/aws/ec2"
+	opsee_types "github.com/opsee/protobuf/opseeproto/types"
+)
+
+var policyInputs = []interface{}{
+	&opsee_aws_ec2.DescribeVpcsInput{},
+	&opsee_aws_ec2.DescribeInstancesInput{},
+	&opsee_aws_cloudwatch.GetMetricStatisticsInput{},
+}
+
+func TestParsePolicy(t *testing.T) {
+	policy, err := ParsePolicy("default=2m, ec2.DescribeVpcs=1h/6h,ec2.DescribeInstances=off", policyInputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if policy.Default != (TTL{MaxAge: 2 * time.Minute}) {
+		t.Errorf("unexpected default: %#v", policy.Default)
+	}
+
+	if ttl := policy.For(&opsee_aws_ec2.DescribeVpcsInput{}); ttl != (TTL{MaxAge: time.Hour, MaxStale: 6 * time.Hour}) {
+		t.Errorf("unexpected ec2.DescribeVpcs ttl: %#v", ttl)
+	}
+
+	if !policy.For(&opsee_aws_ec2.DescribeInstancesInput{}).Disabled {
+		t.Error("expected ec2.DescribeInstances to be disabled")
+	}
+
+	if !policy.For(&opsee_aws_cloudwatch.GetMetricStatisticsInput{}).Disabled {
+		t.Error("expected the default policy's entries to be kept")
+	}
+}
+
+func TestParsePolicyInvalid(t *testing.T) {
+	for _, s := range []string{
+		"ec2.DescribeVpcs",
+		"ec2.DescribeVpcs=soon",
+		"ec2.DescribeVpcs=1h/later",
+		"ec2.DescribeVPCs=1h",
+		"ec2.DescribeVpcsInput=1h",
+		"DescribeVpcs=1h",
+	} {
+		if _, err := ParsePolicy(s, policyInputs); err == nil {
+			t.Errorf("%s: expected an error", s)
+		}
+	}
+}
+
+func TestPolicyApply(t *testing.T) {
+	policy := DefaultPolicy()
+	policy.Types["ec2.DescribeVpcs"] = TTL{MaxAge: time.Hour, MaxStale: 6 * time.Hour}
+
+	req, err := policy.Apply(Request{Input: &opsee_aws_ec2.DescribeVpcsInput{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if req.MaxAge == nil || req.MaxStale != 6*time.Hour {
+		t.Errorf("expected the policy's max age and staleness, got %v, %v", req.MaxAge, req.MaxStale)
+	}
+
+	maxAge := &opsee_types.Timestamp{}
+	maxAge.Scan(time.Now().UTC())
+
+	req, err = policy.Apply(Request{Input: &opsee_aws_ec2.DescribeVpcsInput{}, MaxAge: maxAge, MaxStale: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if req.MaxAge != maxAge || req.MaxStale != 0 {
+		t.Errorf("expected the caller's max age with no staleness, got %v, %v", req.MaxAge, req.MaxStale)
+	}
+}
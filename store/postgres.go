@@ -1,19 +1,65 @@
 package store
 
 import (
-	"encoding/json"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
-	opsee_types "github.com/opsee/protobuf/opseeproto/types"
+	"golang.org/x/net/context"
 	"time"
 )
 
+var (
+	DefaultLockConns = 8
+)
+
 type postgres struct {
-	db *sqlx.DB
+	db        *sqlx.DB
+	locks     *sqlx.DB
+	lockSlots chan struct{}
+	policy    *Policy
+	encoding  string
+	keys      *keyring
+}
+
+// PostgresConfig configures a postgres backed store.
+type PostgresConfig struct {
+	Connection string
+
+	// Policy gives requests without a MaxAge one, DefaultPolicy if it's nil.
+	Policy *Policy
+
+	// Encoding is how responses are saved, EncodingJSON if it's empty.
+	// They're read in whichever encoding they were saved with.
+	Encoding string
+
+	// MasterKeys turns on encryption of requests and responses with
+	// per-customer data keys, which the first master key wraps. The rest
+	// only unwrap keys from before it was rotated.
+	MasterKeys []MasterKey
+
+	// LockConns is the size of the pool refresh locks are taken from,
+	// DefaultLockConns if it's zero. It's kept apart from the main pool,
+	// since a lock's connection is held while its holder reads and writes
+	// resources on others, and it bounds how many distinct keys a replica
+	// can refresh under lock at once.
+	LockConns int
 }
 
-func NewPostgres(connection string) (Store, error) {
-	db, err := sqlx.Open("postgres", connection)
+// NewPostgres returns a Store backed by the resources table.
+func NewPostgres(config PostgresConfig) (Store, error) {
+	encoding := config.Encoding
+	if encoding == "" {
+		encoding = EncodingJSON
+	}
+
+	if !validEncoding(encoding) {
+		return nil, errUnknownEncoding
+	}
+
+	db, err := sqlx.Open("postgres", config.Connection)
 	if err != nil {
 		return nil, err
 	}
@@ -21,9 +67,40 @@ func NewPostgres(connection string) (Store, error) {
 	db.SetMaxOpenConns(8)
 	db.SetMaxIdleConns(8)
 
-	return &postgres{
-		db: db,
-	}, nil
+	locks, err := sqlx.Open("postgres", config.Connection)
+	if err != nil {
+		return nil, err
+	}
+
+	lockConns := config.LockConns
+	if lockConns <= 0 {
+		lockConns = DefaultLockConns
+	}
+
+	locks.SetMaxOpenConns(lockConns)
+	locks.SetMaxIdleConns(lockConns)
+
+	policy := config.Policy
+	if policy == nil {
+		policy = DefaultPolicy()
+	}
+
+	s := &postgres{
+		db:        db,
+		locks:     locks,
+		lockSlots: make(chan struct{}, lockConns),
+		policy:    policy,
+		encoding:  encoding,
+	}
+
+	if len(config.MasterKeys) > 0 {
+		s.keys, err = newKeyring(db, config.MasterKeys)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
 }
 
 func (s *postgres) Put(req Request) error {
@@ -41,77 +118,255 @@ func (s *postgres) Put(req Request) error {
 	return tx.Commit()
 }
 
-func (s *postgres) Get(req Request) error {
+func (s *postgres) Get(req Request) (*Metadata, error) {
 	return s.get(s.db, req)
 }
 
+// Lock takes a transaction scoped advisory lock on key, which is released when
+// the returned func commits the transaction. Locks come from their own pool,
+// so holders can't starve the main pool of the connections they need to
+// finish and unlock. If every lock connection is in use it fails straight
+// away rather than queueing for one.
+//
+// Waiting for the lock is bounded by DefaultLockTimeout and ctx's deadline,
+// and given up when ctx is done. The wait itself can't be interrupted, so an
+// abandoned lock is released, and its connection freed, once it's acquired
+// or times out.
+func (s *postgres) Lock(ctx context.Context, key string) (func() error, error) {
+	select {
+	case s.lockSlots <- struct{}{}:
+	default:
+		return nil, errLocksExhausted
+	}
+
+	timeout := DefaultLockTimeout
+	if deadline, ok := ctx.Deadline(); ok && deadline.Sub(time.Now()) < timeout {
+		timeout = deadline.Sub(time.Now())
+	}
+
+	if timeout < time.Millisecond {
+		<-s.lockSlots
+		return nil, context.DeadlineExceeded
+	}
+
+	type locked struct {
+		tx  *sqlx.Tx
+		err error
+	}
+
+	done := make(chan locked, 1)
+	go func() {
+		tx, err := s.lock(key, timeout)
+		done <- locked{tx, err}
+	}()
+
+	release := func(tx *sqlx.Tx, end func() error) error {
+		defer func() { <-s.lockSlots }()
+		return end()
+	}
+
+	select {
+	case l := <-done:
+		if l.err != nil {
+			<-s.lockSlots
+			return nil, l.err
+		}
+
+		return func() error { return release(l.tx, l.tx.Commit) }, nil
+
+	case <-ctx.Done():
+		go func() {
+			if l := <-done; l.err == nil {
+				release(l.tx, l.tx.Rollback)
+			} else {
+				<-s.lockSlots
+			}
+		}()
+
+		return nil, ctx.Err()
+	}
+}
+
+// lock opens a transaction on the lock pool and waits up to timeout for the
+// advisory lock on key in it.
+func (s *postgres) lock(key string, timeout time.Duration) (*sqlx.Tx, error) {
+	tx, err := s.locks.Beginx()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("set local lock_timeout = %d", timeout/time.Millisecond))
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	_, err = tx.Exec(`select pg_advisory_xact_lock($1)`, lockId(key))
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+// postgresStore returns the postgres store behind a store, if there is one.
+func postgresStore(s Store) (*postgres, bool) {
+	switch s := s.(type) {
+	case *postgres:
+		return s, true
+	case *layered:
+		return s.remote, true
+	}
+
+	return nil, false
+}
+
+// DBStats returns the connection pool stats of a postgres backed store.
+func DBStats(s Store) (sql.DBStats, bool) {
+	p, ok := postgresStore(s)
+	if !ok {
+		return sql.DBStats{}, false
+	}
+
+	return p.db.Stats(), true
+}
+
+// lockId maps a key onto postgres' bigint advisory lock space.
+func lockId(key string) int64 {
+	sum := sha256.Sum256([]byte(key))
+	return int64(binary.BigEndian.Uint64(sum[:8]))
+}
+
 func (s *postgres) put(x sqlx.Ext, req Request) error {
 	if err := req.validate(); err != nil {
 		return err
 	}
 
-	resource, err := req.resource()
+	resource, err := req.resource(s.encoding)
 	if err != nil {
 		return err
 	}
 
+	if err := s.encrypt(resource); err != nil {
+		return err
+	}
+
+	// updated_at is set here rather than by trigger, see 0007_resource_encoding
 	_, err = sqlx.NamedExec(
 		x,
-		`insert into resources (id, customer_id, request_type, request_data, response_type, response_data)
-		 values (:id, :customer_id, :request_type, :request_data, :response_type, :response_data)
-	         on conflict on constraint resources_pkey do update set (id, customer_id, request_type, request_data, response_type, response_data) =
-		 (:id, :customer_id, :request_type, :request_data, :response_type, :response_data)`,
+		`insert into resources (id, customer_id, region, vpc_id, request_type, request_data, request_blob, response_type, response_data, response_blob, encoding, key_version, aws_request_id)
+		 values (:id, :customer_id, :region, :vpc_id, :request_type, :request_data, :request_blob, :response_type, :response_data, :response_blob, :encoding, :key_version, :aws_request_id)
+	         on conflict on constraint resources_pkey do update set (id, customer_id, region, vpc_id, request_type, request_data, request_blob, response_type, response_data, response_blob, encoding, key_version, aws_request_id, updated_at) =
+		 (:id, :customer_id, :region, :vpc_id, :request_type, :request_data, :request_blob, :response_type, :response_data, :response_blob, :encoding, :key_version, :aws_request_id, now())`,
 		resource,
 	)
 
 	return err
 }
 
-func (s *postgres) get(x sqlx.Ext, req Request) error {
+func (s *postgres) get(x sqlx.Ext, req Request) (*Metadata, error) {
 	if err := req.validate(); err != nil {
-		return err
+		return nil, err
 	}
 
-	resource, err := req.resource()
+	id, err := cacheKey(req.Input, req.AllPages)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	resource := &resource{}
 	err = sqlx.Get(
 		x,
 		resource,
-		`select * from resources where id = $1 and customer_id = $2`,
-		resource.Id,
-		resource.CustomerId,
+		`select * from resources where id = $1 and customer_id = $2 and region = $3 and vpc_id = $4`,
+		id,
+		req.CustomerId,
+		req.Region,
+		req.VpcId,
 	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.decrypt(resource); err != nil {
+		return nil, err
+	}
+
+	return req.hydrate(resource, s.policy)
+}
+
+// encrypt seals a resource's request and response with its customer's latest
+// data key, moving both into the blob columns. It does nothing if encryption
+// is off.
+func (s *postgres) encrypt(r *resource) error {
+	if s.keys == nil {
+		return nil
+	}
+
+	version, aead, err := s.keys.latestKey(r.CustomerId)
 	if err != nil {
 		return err
 	}
 
-	// this shouldn't happen haha
-	if resource.UpdatedAt == nil {
-		return errMissingUpdated
+	response := r.ResponseData
+	if r.Encoding == EncodingProto {
+		response = r.ResponseBlob
 	}
 
-	// if we don't have a max age set, give it a default
-	if req.MaxAge == nil {
-		req.MaxAge = &opsee_types.Timestamp{}
-		err = req.MaxAge.Scan(time.Now().UTC().Add(-1 * DefaultTTL))
-		if err != nil {
-			return err
-		}
+	r.RequestBlob, err = seal(aead, r.RequestData, r.aad("request"))
+	if err != nil {
+		return err
+	}
+
+	r.ResponseBlob, err = seal(aead, response, r.aad("response"))
+	if err != nil {
+		return err
+	}
+
+	r.RequestData = nil
+	r.ResponseData = nil
+	r.KeyVersion = &version
+
+	return nil
+}
+
+// decrypt opens an encrypted resource, putting its request and response back
+// where they are in plaintext resources.
+func (s *postgres) decrypt(r *resource) error {
+	if r.KeyVersion == nil {
+		return nil
+	}
+
+	if s.keys == nil {
+		return errMissingMasterKey
+	}
+
+	aead, err := s.keys.key(r.CustomerId, *r.KeyVersion)
+	if err != nil {
+		return err
 	}
 
-	// stuff in the db is expired, just ignore it
-	if resource.UpdatedAt.Millis() < req.MaxAge.Millis() {
-		return errResourceExpired
+	request, err := open(aead, r.RequestBlob, r.aad("request"))
+	if err != nil {
+		return err
 	}
 
-	// ok we good, try 2 re-hydrate
-	err = json.Unmarshal(resource.ResponseData, req.Output)
+	response, err := open(aead, r.ResponseBlob, r.aad("response"))
 	if err != nil {
 		return err
 	}
 
+	r.RequestData = request
+	r.RequestBlob = nil
+
+	if r.Encoding == EncodingProto {
+		r.ResponseBlob = response
+	} else {
+		r.ResponseData = response
+		r.ResponseBlob = nil
+	}
+
+	r.KeyVersion = nil
 	return nil
 }
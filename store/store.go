@@ -1,42 +1,83 @@
 package store
 
 import (
-	"bytes"
-	"encoding/gob"
 	"encoding/json"
-	"fmt"
 	opsee_types "github.com/opsee/protobuf/opseeproto/types"
-	"hash/crc64"
+	"golang.org/x/net/context"
 	"reflect"
+	"strings"
 	"time"
 )
 
 var (
-	DefaultTTL = 2 * time.Minute
-	crcTable   = crc64.MakeTable(crc64.ISO)
+	DefaultTTL         = 2 * time.Minute
+	DefaultLockTimeout = 30 * time.Second
 )
 
 type Store interface {
-	Get(Request) error
+	Get(Request) (*Metadata, error)
 	Put(Request) error
 }
 
+// Locker is implemented by stores that can coordinate work between bezosphere
+// replicas. Lock blocks until no other replica holds key, or ctx is done, and
+// returns a func to release it.
+type Locker interface {
+	Lock(ctx context.Context, key string) (func() error, error)
+}
+
+// Metadata describes the provenance of a cached resource.
+type Metadata struct {
+	UpdatedAt    *opsee_types.Timestamp
+	AWSRequestId string
+	Stale        bool
+
+	// Error is set when the request failed the last time it was made, and
+	// the failure was cached instead of an output. The output is left alone.
+	Error *Error
+}
+
 type resource struct {
 	Id           string
 	CustomerId   string                 `db:"customer_id"`
+	Region       string                 `db:"region"`
+	VpcId        string                 `db:"vpc_id"`
 	RequestType  string                 `db:"request_type"`
 	RequestData  []byte                 `db:"request_data"`
+	RequestBlob  []byte                 `db:"request_blob"`
 	ResponseType string                 `db:"response_type"`
 	ResponseData []byte                 `db:"response_data"`
+	ResponseBlob []byte                 `db:"response_blob"`
+	Encoding     string                 `db:"encoding"`
+	KeyVersion   *int                   `db:"key_version"`
+	AWSRequestId string                 `db:"aws_request_id"`
 	CreatedAt    *opsee_types.Timestamp `db:"created_at"`
 	UpdatedAt    *opsee_types.Timestamp `db:"updated_at"`
 }
 
 type Request struct {
 	CustomerId string
+	Region     string
+	VpcId      string
 	Input      interface{}
 	Output     interface{}
 	MaxAge     *opsee_types.Timestamp
+
+	// MaxStale allows Get to return resources up to MaxStale older than
+	// MaxAge, with Metadata.Stale set.
+	MaxStale time.Duration
+
+	// AllPages marks an output merged from every page of a paginated
+	// operation, which is cached separately from the single page version.
+	AllPages bool
+
+	// AWSRequestId is the id of the AWS call that produced Output, it is
+	// saved on Put and returned in Metadata on Get.
+	AWSRequestId string
+
+	// Error makes Put cache an AWS error for the request instead of its
+	// output.
+	Error *Error
 }
 
 func (req Request) validate() error {
@@ -44,6 +85,14 @@ func (req Request) validate() error {
 		return errMissingCustomerId
 	}
 
+	if req.Region == "" {
+		return errMissingRegion
+	}
+
+	if req.VpcId == "" {
+		return errMissingVpcId
+	}
+
 	if req.Input == nil {
 		return errMissingAWSRequestInput
 	}
@@ -55,38 +104,114 @@ func (req Request) validate() error {
 	return nil
 }
 
-func (req Request) resource() (*resource, error) {
-	id, err := checksum(req.Input)
+// Key identifies the resource a request refers to across every customer,
+// region and vpc. Requests with the same key share a row in the cache.
+func (req Request) Key() (string, error) {
+	if err := req.validate(); err != nil {
+		return "", err
+	}
+
+	id, err := cacheKey(req.Input, req.AllPages)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
-	rd, err := json.Marshal(req.Input)
+	return strings.Join([]string{req.CustomerId, req.Region, req.VpcId, id}, "/"), nil
+}
+
+func (req Request) resource(encoding string) (*resource, error) {
+	id, err := cacheKey(req.Input, req.AllPages)
 	if err != nil {
 		return nil, err
 	}
 
-	resd, err := json.Marshal(req.Output)
+	rd, err := json.Marshal(req.Input)
 	if err != nil {
 		return nil, err
 	}
 
-	return &resource{
-		Id:           fmt.Sprintf("%d", id),
+	r := &resource{
+		Id:           id,
 		CustomerId:   req.CustomerId,
+		Region:       req.Region,
+		VpcId:        req.VpcId,
 		RequestType:  reflect.TypeOf(req.Input).String(),
 		RequestData:  rd,
-		ResponseType: reflect.TypeOf(req.Output).String(),
-		ResponseData: resd,
-	}, nil
+		AWSRequestId: req.AWSRequestId,
+	}
+
+	// errors are always json, they're small and don't have an output type
+	if req.Error != nil {
+		r.ResponseType = errorResponseType
+		r.Encoding = EncodingJSON
+		r.ResponseData, err = json.Marshal(req.Error)
+		return r, err
+	}
+
+	r.ResponseType = reflect.TypeOf(req.Output).String()
+	r.Encoding = encoding
+	r.ResponseData, r.ResponseBlob, err = encodeOutput(req.Output, encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
 }
 
-func checksum(v interface{}) (uint64, error) {
-	var buf bytes.Buffer
-	err := gob.NewEncoder(&buf).Encode(v)
+// hydrate fills in the request's output from a cached resource, unless it's
+// older than the request's MaxAge allows. Cached errors are returned in the
+// metadata instead.
+func (req Request) hydrate(resource *resource, policy *Policy) (*Metadata, error) {
+	if resource.ResponseType == errorResponseType {
+		return req.errorMetadata(resource)
+	}
+
+	meta, err := req.metadata(resource, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	// ok we good, try 2 re-hydrate
+	err = decodeOutput(resource, req.Output)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	return crc64.Checksum(buf.Bytes(), crcTable), nil
+	return meta, nil
+}
+
+// metadata checks a cached resource is fresh enough for the request, and
+// describes it. Requests without a MaxAge take it from policy.
+func (req Request) metadata(resource *resource, policy *Policy) (*Metadata, error) {
+	var err error
+
+	// this shouldn't happen haha
+	if resource.UpdatedAt == nil {
+		return nil, errMissingUpdated
+	}
+
+	// if we don't have a max age set, take it from the policy
+	if req.MaxAge == nil {
+		req.MaxAge, err = policy.MaxAge(req.Input)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// stuff in the cache is expired, just ignore it
+	if resource.UpdatedAt.Millis() < req.MaxAge.Millis()-int64(req.MaxStale/time.Millisecond) {
+		return nil, errResourceExpired
+	}
+
+	return &Metadata{
+		UpdatedAt:    resource.UpdatedAt,
+		AWSRequestId: resource.AWSRequestId,
+		Stale:        resource.UpdatedAt.Millis() < req.MaxAge.Millis(),
+	}, nil
+}
+
+// aad binds an encrypted column to its resource, so it can't be moved to
+// another row or column and still decrypt.
+func (r *resource) aad(column string) []byte {
+	return []byte(strings.Join([]string{r.CustomerId, r.Region, r.VpcId, r.Id, column}, "/"))
 }